@@ -2,6 +2,9 @@ package addressinfo
 
 import "github.com/glossd/btc/netchain"
 
+// CoinbaseMaturity is the number of confirmations the reward of the miner needs to be spent.
+const CoinbaseMaturity = 100
+
 type Address struct {
 	Balance int64
 	UTXOs   []UTXO
@@ -12,9 +15,18 @@ type UTXO struct {
 	Pbscript string
 	Balance  int64
 	TxOutIdx int
+	// Number of the blocks mined since the block with the transaction of the UTXO, 0 if it's unconfirmed.
+	Confirmations int
+	// Height of the block with the transaction of the UTXO, 0 if it's unconfirmed or unknown.
+	BlockHeight int
+	// Whether the UTXO is the reward of the miner, which can be spent only after CoinbaseMaturity confirmations.
+	IsCoinbase bool
 }
 
 type Fetch func(address string, net netchain.Net) (Address, error)
 
+// FetchRawTx returns the hex-encoded transaction.
+type FetchRawTx func(txID string, net netchain.Net) (string, error)
+
 // GetSatoshiPerByte returns minimum 'good-enough' satoshi per byte rate.
 type GetSatoshiPerByte func(net netchain.Net) (int, error)
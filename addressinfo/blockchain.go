@@ -6,6 +6,8 @@ import (
 	"github.com/glossd/btc/netchain"
 	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
 )
 
 type blockchainResponse struct {
@@ -17,18 +19,19 @@ type blockchainUTXO struct {
 	TxOutputN int    `json:"tx_output_n"`
 	Script    string `json:"script"`
 	Value     int64  `json:"value"`
+	// 0 for unconfirmed outputs.
+	Confirmations int `json:"confirmations"`
 }
 
 func FetchFromBlockchain(address string, net netchain.Net) (Address, error) {
 	if net != netchain.MainNet {
-		return Address{}, fmt.Errorf("only mainnet is supported fetching UTXOs from blockchain.info")
+		return Address{}, fmt.Errorf("%w, only mainnet is supported fetching UTXOs from blockchain.info", ErrNetNotSupported)
 	}
 	resp, err := http.Get(fmt.Sprintf("https://blockchain.info/unspent?active=%s", address))
 	if err != nil {
 		return Address{}, err
 	}
-	defer resp.Body.Close()
-	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	bodyBytes, err := readBlockchainResponse(resp)
 	if err != nil {
 		return Address{}, err
 	}
@@ -38,30 +41,99 @@ func FetchFromBlockchain(address string, net netchain.Net) (Address, error) {
 	if err != nil {
 		return Address{}, err
 	}
-	utxos := make([]UTXO, 0, len(data.UnspentOutputs))
+	return toBlockchainAddress(data.UnspentOutputs, getBlockCountFromBlockchain, isCoinbaseOnBlockchain)
+}
+
+// toBlockchainAddress fills the heights of the unspent outputs and whether they are coinbase,
+// looking up each transaction once.
+func toBlockchainAddress(outputs []blockchainUTXO, getBlockCount func() (int, error), isCoinbase func(txID string) (bool, error)) (Address, error) {
+	utxos := make([]UTXO, 0, len(outputs))
 	var balance int64
-	for _, output := range data.UnspentOutputs {
-		utxos = append(utxos, UTXO{
-			TxID:     output.TxID,
-			Pbscript: output.Script,
-			Balance:  output.Value,
-			TxOutIdx: output.TxOutputN,
-		})
+	var tipHeight int
+	coinbaseOf := make(map[string]bool)
+	for _, output := range outputs {
+		utxo := UTXO{
+			TxID:          output.TxID,
+			Pbscript:      output.Script,
+			Balance:       output.Value,
+			TxOutIdx:      output.TxOutputN,
+			Confirmations: output.Confirmations,
+		}
+		if output.Confirmations > 0 {
+			if tipHeight == 0 {
+				var err error
+				tipHeight, err = getBlockCount()
+				if err != nil {
+					return Address{}, err
+				}
+			}
+			utxo.BlockHeight = tipHeight - output.Confirmations + 1
+		}
+		// the unspent outputs don't tell the coinbase ones, only the immature ones matter
+		if output.Confirmations > 0 && output.Confirmations < CoinbaseMaturity {
+			coinbase, ok := coinbaseOf[output.TxID]
+			if !ok {
+				var err error
+				coinbase, err = isCoinbase(output.TxID)
+				if err != nil {
+					return Address{}, err
+				}
+				coinbaseOf[output.TxID] = coinbase
+			}
+			utxo.IsCoinbase = coinbase
+		}
+		utxos = append(utxos, utxo)
 		balance += output.Value
 	}
 	return Address{UTXOs: utxos, Balance: balance}, nil
 }
 
+func getBlockCountFromBlockchain() (int, error) {
+	resp, err := http.Get("https://blockchain.info/q/getblockcount")
+	if err != nil {
+		return 0, err
+	}
+	bodyBytes, err := readBlockchainResponse(resp)
+	if err != nil {
+		return 0, err
+	}
+	height, err := strconv.Atoi(strings.TrimSpace(string(bodyBytes)))
+	if err != nil {
+		return 0, fmt.Errorf("couldn't parse the block count: %w", err)
+	}
+	return height, nil
+}
+
+func isCoinbaseOnBlockchain(txID string) (bool, error) {
+	resp, err := http.Get(fmt.Sprintf("https://blockchain.info/rawtx/%s", txID))
+	if err != nil {
+		return false, err
+	}
+	bodyBytes, err := readBlockchainResponse(resp)
+	if err != nil {
+		return false, err
+	}
+	var tx struct {
+		Inputs []struct {
+			PrevOut *json.RawMessage `json:"prev_out"`
+		} `json:"inputs"`
+	}
+	err = json.Unmarshal(bodyBytes, &tx)
+	if err != nil {
+		return false, err
+	}
+	return len(tx.Inputs) == 1 && tx.Inputs[0].PrevOut == nil, nil
+}
+
 func GetSatoshiPerByteFromBlockchain(net netchain.Net) (int, error) {
 	if net != netchain.MainNet {
-		return 0, fmt.Errorf("only mainnet is supported for blockchain.info")
+		return 0, fmt.Errorf("%w, only mainnet is supported for blockchain.info", ErrNetNotSupported)
 	}
 	resp, err := http.Get(fmt.Sprintf("https://api.blockchain.info/mempool/fees"))
 	if err != nil {
 		return 0, err
 	}
-	defer resp.Body.Close()
-	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	bodyBytes, err := readBlockchainResponse(resp)
 	if err != nil {
 		return 0, err
 	}
@@ -79,3 +151,15 @@ func GetSatoshiPerByteFromBlockchain(net netchain.Net) (int, error) {
 	}
 	return res.Priority, nil
 }
+
+func readBlockchainResponse(resp *http.Response) ([]byte, error) {
+	defer resp.Body.Close()
+	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &ProviderError{Provider: "blockchain.info", Status: resp.StatusCode, Body: string(bodyBytes)}
+	}
+	return bodyBytes, nil
+}
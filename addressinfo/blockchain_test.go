@@ -1,6 +1,7 @@
 package addressinfo
 
 import (
+	"errors"
 	"github.com/glossd/btc/netchain"
 	"github.com/stretchr/testify/assert"
 	"testing"
@@ -22,3 +23,39 @@ func TestGetSatoshiPerByteFromBlockchain(t *testing.T) {
 	}
 	assert.Positive(t, spb)
 }
+
+func TestToBlockchainAddress(t *testing.T) {
+	outputs := []blockchainUTXO{
+		{TxID: "coinbase", TxOutputN: 0, Value: 1000, Confirmations: 10},
+		{TxID: "coinbase", TxOutputN: 1, Value: 2000, Confirmations: 10},
+		{TxID: "regular", TxOutputN: 0, Value: 3000, Confirmations: 10},
+		{TxID: "mature", TxOutputN: 0, Value: 4000, Confirmations: CoinbaseMaturity},
+		{TxID: "unconfirmed", TxOutputN: 0, Value: 5000},
+	}
+	lookups := make(map[string]int)
+	got, err := toBlockchainAddress(outputs, func() (int, error) {
+		return 1000, nil
+	}, func(txID string) (bool, error) {
+		lookups[txID]++
+		return txID == "coinbase", nil
+	})
+	assert.Nil(t, err)
+	assert.EqualValues(t, 15000, got.Balance)
+	// each immature transaction is looked up once
+	assert.EqualValues(t, map[string]int{"coinbase": 1, "regular": 1}, lookups)
+	assert.True(t, got.UTXOs[0].IsCoinbase)
+	assert.True(t, got.UTXOs[1].IsCoinbase)
+	assert.False(t, got.UTXOs[2].IsCoinbase)
+	assert.False(t, got.UTXOs[3].IsCoinbase)
+	assert.EqualValues(t, 991, got.UTXOs[0].BlockHeight)
+	assert.EqualValues(t, 0, got.UTXOs[4].BlockHeight)
+
+	_, err = toBlockchainAddress(outputs, func() (int, error) {
+		return 1000, nil
+	}, func(txID string) (bool, error) {
+		return false, &ProviderError{Provider: "blockchain.info", Status: 429, Body: "rate limit"}
+	})
+	var providerErr *ProviderError
+	assert.True(t, errors.As(err, &providerErr))
+	assert.EqualValues(t, 429, providerErr.Status)
+}
@@ -1,29 +1,42 @@
 package addressinfo
 
 import (
-	"github.com/blockcypher/gobcy"
+	"github.com/glossd/btc/internal/blockcypher"
 	"github.com/glossd/btc/netchain"
-	"os"
 )
 
 func FetchFromBlockcypher(address string, net netchain.Net) (Address, error) {
-	btc := gobcy.API{Token: os.Getenv("BTC_API_KEY"), Coin: "btc", Chain: net.GetBlockcypherChain()}
-	info, err := btc.GetAddrFull(address, map[string]string{})
+	info, err := blockcypher.GetAddrFull(address, net)
 	if err != nil {
 		return Address{}, err
 	}
 	var utxos []UTXO
 	for _, tx := range info.TXs {
+		if tx.DoubleSpend {
+			// the conflicting transaction may be mined instead
+			continue
+		}
+		var blockHeight int
+		if tx.BlockHeight > 0 {
+			blockHeight = tx.BlockHeight
+		}
 		for outputIdx, output := range tx.Outputs {
 			if len(output.Addresses) == 1 && output.Addresses[0] == address {
 				if output.SpentBy == "" {
-					utxos = append(utxos, UTXO{TxID: tx.Hash, Balance: output.Value.Int64(), Pbscript: output.Script, TxOutIdx: outputIdx})
+					utxos = append(utxos, UTXO{TxID: tx.Hash, Balance: output.Value, Pbscript: output.Script, TxOutIdx: outputIdx,
+						Confirmations: tx.Confirmations, BlockHeight: blockHeight, IsCoinbase: tx.IsCoinbase()})
 				}
 			}
 		}
 	}
 
-	return Address{UTXOs: utxos, Balance: info.Balance.Int64()}, nil
+	return Address{UTXOs: utxos, Balance: info.Balance}, nil
 }
 
-
+func FetchRawTxFromBlockcypher(txID string, net netchain.Net) (string, error) {
+	tx, err := blockcypher.GetTX(txID, map[string]string{"includeHex": "true", "limit": "1"}, net)
+	if err != nil {
+		return "", err
+	}
+	return tx.Hex, nil
+}
@@ -0,0 +1,12 @@
+package addressinfo
+
+import (
+	"errors"
+	"github.com/glossd/btc/internal/provider"
+)
+
+// ProviderError is returned when the API responds with an unexpected status, e.g. when the rate limit is exceeded.
+type ProviderError = provider.Error
+
+// ErrNetNotSupported is returned by the providers which only serve some of the nets.
+var ErrNetNotSupported = errors.New("net chain isn't supported by the provider")
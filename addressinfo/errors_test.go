@@ -0,0 +1,27 @@
+package addressinfo
+
+import (
+	"errors"
+	"github.com/glossd/btc/netchain"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestProviderError(t *testing.T) {
+	_, err := readBlockchainResponse(&http.Response{
+		StatusCode: http.StatusTooManyRequests,
+		Body:       ioutil.NopCloser(strings.NewReader("rate limit")),
+	})
+	var providerErr *ProviderError
+	assert.True(t, errors.As(err, &providerErr))
+	assert.EqualValues(t, http.StatusTooManyRequests, providerErr.Status)
+	assert.EqualValues(t, "rate limit", providerErr.Body)
+}
+
+func TestFetchFromBlockchain_TestNet(t *testing.T) {
+	_, err := FetchFromBlockchain("mop76RFpxCMpNBx2M2NtAJsZEmo6qu5PSa", netchain.TestNet)
+	assert.True(t, errors.Is(err, ErrNetNotSupported))
+}
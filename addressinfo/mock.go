@@ -1,18 +1,33 @@
 package addressinfo
 
 import (
-	"github.com/btcsuite/btcd/wire"
+	"encoding/hex"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
 	"github.com/glossd/btc/netchain"
 )
 
 const MockAddressBalance int64 = 1e6
 
+// FetchMock gives the address one confirmed UTXO of MockAddressBalance locked with the script of the address,
+// every address gets its own outpoint.
 func FetchMock(address string, net netchain.Net) (Address, error) {
+	addr, err := btcutil.DecodeAddress(address, net.GetBtcdNetParams())
+	if err != nil {
+		return Address{}, err
+	}
+	script, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		return Address{}, err
+	}
 	var utxoMock = UTXO{
-		TxID:     wire.NewMsgTx(wire.TxVersion).TxHash().String(),
+		TxID:     chainhash.HashH([]byte(address)).String(),
 		Balance:  MockAddressBalance,
-		Pbscript: "76a914fee7132bbe9201c4f1a0f846b5f714d9335e263088ac",
+		Pbscript: hex.EncodeToString(script),
 		TxOutIdx: 1,
+
+		Confirmations: 6,
 	}
 	return Address{Balance: utxoMock.Balance, UTXOs: []UTXO{utxoMock}}, nil
 }
@@ -0,0 +1,135 @@
+// Package blockcypher is a minimal client of the Blockcypher REST API, see https://www.blockcypher.com/dev/bitcoin/
+// The token is taken from BTC_API_KEY env var.
+package blockcypher
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/glossd/btc/internal/provider"
+	"github.com/glossd/btc/netchain"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// baseURL is a var so that the tests can point it to their server.
+var baseURL = "https://api.blockcypher.com/v1/btc/"
+
+// client gives up on the slow responses instead of blocking the caller forever.
+var client = &http.Client{Timeout: 30 * time.Second}
+
+type Addr struct {
+	Address string `json:"address"`
+	Balance int64  `json:"balance"`
+	TXs     []TX   `json:"txs"`
+}
+
+type TX struct {
+	Hash          string     `json:"hash"`
+	Confirmations int        `json:"confirmations"`
+	BlockHeight   int        `json:"block_height"` // -1 for unconfirmed transactions
+	DoubleSpend   bool       `json:"double_spend"`
+	Hex           string     `json:"hex"`
+	Inputs        []TXInput  `json:"inputs"`
+	Outputs       []TXOutput `json:"outputs"`
+}
+
+// IsCoinbase tells whether the transaction is the reward of the miner, its only input spends nothing.
+func (tx TX) IsCoinbase() bool {
+	return len(tx.Inputs) == 1 && tx.Inputs[0].PrevHash == "" && tx.Inputs[0].OutputIndex == -1
+}
+
+type TXInput struct {
+	PrevHash    string `json:"prev_hash"`
+	OutputIndex int    `json:"output_index"`
+}
+
+type TXOutput struct {
+	Value     int64    `json:"value"`
+	Script    string   `json:"script"`
+	Addresses []string `json:"addresses"`
+	SpentBy   string   `json:"spent_by"`
+}
+
+// GetAddrFull returns the address with its transactions.
+func GetAddrFull(address string, net netchain.Net) (Addr, error) {
+	var addr Addr
+	err := get("/addrs/"+address+"/full", nil, net, &addr)
+	return addr, err
+}
+
+func GetTX(hash string, params map[string]string, net netchain.Net) (TX, error) {
+	var tx TX
+	err := get("/txs/"+hash, params, net, &tx)
+	return tx, err
+}
+
+// PushTX broadcasts the raw transaction and returns its hash.
+func PushTX(rawTx string, net netchain.Net) (string, error) {
+	var res struct {
+		TX TX `json:"tx"`
+	}
+	err := post("/txs/push", map[string]string{"tx": rawTx}, net, &res)
+	return res.TX.Hash, err
+}
+
+func get(path string, params map[string]string, net netchain.Net, result interface{}) error {
+	u, err := buildURL(path, params, net)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Get(u)
+	if err != nil {
+		return err
+	}
+	return decodeResponse(resp, result)
+}
+
+func post(path string, body interface{}, net netchain.Net, result interface{}) error {
+	reqBody, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	u, err := buildURL(path, nil, net)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post(u, "application/json", bytes.NewReader(reqBody))
+	if err != nil {
+		return err
+	}
+	return decodeResponse(resp, result)
+}
+
+func decodeResponse(resp *http.Response, result interface{}) error {
+	defer resp.Body.Close()
+	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return &provider.Error{Provider: "blockcypher", Status: resp.StatusCode, Body: string(bodyBytes)}
+	}
+	return json.Unmarshal(bodyBytes, result)
+}
+
+func buildURL(path string, params map[string]string, net netchain.Net) (string, error) {
+	if net != netchain.MainNet && net != netchain.TestNet {
+		return "", fmt.Errorf("net chain '%s' is not supported", net)
+	}
+	values := url.Values{}
+	for k, v := range params {
+		values.Set(k, v)
+	}
+	if token := os.Getenv("BTC_API_KEY"); token != "" {
+		values.Set("token", token)
+	}
+	u := baseURL + net.GetBlockcypherChain() + path
+	if len(values) > 0 {
+		u += "?" + values.Encode()
+	}
+	return u, nil
+}
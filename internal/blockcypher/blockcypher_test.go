@@ -0,0 +1,81 @@
+package blockcypher
+
+import (
+	"encoding/json"
+	"github.com/glossd/btc/netchain"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetAddrFull(t *testing.T) {
+	serve(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.EqualValues(t, http.MethodGet, r.Method)
+		assert.EqualValues(t, "/test3/addrs/mop76RFpxCMpNBx2M2NtAJsZEmo6qu5PSa/full", r.URL.Path)
+		w.Write([]byte(`{"address":"mop76RFpxCMpNBx2M2NtAJsZEmo6qu5PSa","balance":1500,"txs":[{"hash":"ab","confirmations":3,"outputs":[{"value":1500,"script":"76a9","addresses":["mop76RFpxCMpNBx2M2NtAJsZEmo6qu5PSa"]}]}]}`))
+	})
+
+	addr, err := GetAddrFull("mop76RFpxCMpNBx2M2NtAJsZEmo6qu5PSa", netchain.TestNet)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1500, addr.Balance)
+	assert.EqualValues(t, 1, len(addr.TXs))
+	assert.EqualValues(t, "ab", addr.TXs[0].Hash)
+	assert.EqualValues(t, 3, addr.TXs[0].Confirmations)
+	assert.EqualValues(t, 1500, addr.TXs[0].Outputs[0].Value)
+}
+
+func TestGetTX(t *testing.T) {
+	serve(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.EqualValues(t, "/main/txs/ab", r.URL.Path)
+		assert.EqualValues(t, "1", r.URL.Query().Get("limit"))
+		w.Write([]byte(`{"hash":"ab","confirmations":7}`))
+	})
+
+	tx, err := GetTX("ab", map[string]string{"limit": "1"}, netchain.MainNet)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 7, tx.Confirmations)
+}
+
+func TestPushTX(t *testing.T) {
+	serve(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.EqualValues(t, http.MethodPost, r.Method)
+		assert.EqualValues(t, "/test3/txs/push", r.URL.Path)
+		var body map[string]string
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&body))
+		assert.EqualValues(t, "0100", body["tx"])
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"tx":{"hash":"ab"}}`))
+	})
+
+	hash, err := PushTX("0100", netchain.TestNet)
+	assert.Nil(t, err)
+	assert.EqualValues(t, "ab", hash)
+}
+
+func TestErrorStatus(t *testing.T) {
+	serve(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte("rate limit"))
+	})
+
+	_, err := GetTX("ab", nil, netchain.MainNet)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "rate limit")
+}
+
+func TestClientTimeout(t *testing.T) {
+	assert.Positive(t, int64(client.Timeout))
+}
+
+// serve points the client to the test server until the test ends.
+func serve(t *testing.T, handler http.HandlerFunc) {
+	t.Setenv("BTC_API_KEY", "")
+	server := httptest.NewServer(handler)
+	oldURL := baseURL
+	baseURL = server.URL + "/"
+	t.Cleanup(func() {
+		baseURL = oldURL
+		server.Close()
+	})
+}
@@ -0,0 +1,16 @@
+// Package provider holds what the clients of the blockchain APIs share.
+package provider
+
+import "fmt"
+
+// Error is returned when the API responds with an unexpected status.
+type Error struct {
+	// Name of the API, e.g. blockcypher.
+	Provider string
+	Status   int
+	Body     string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s responded with status %d: %s", e.Provider, e.Status, e.Body)
+}
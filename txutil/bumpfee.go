@@ -0,0 +1,273 @@
+package txutil
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/glossd/btc/addressinfo"
+	"github.com/glossd/btc/netchain"
+)
+
+// DefaultIncrementalRelayFee in sat/vB is what a replacement must pay for its own size
+// on top of the fee of the replaced transaction, the default of Bitcoin Core.
+const DefaultIncrementalRelayFee = 1
+
+// The highest sequence signaling BIP125 replaceability, which also keeps the locktime enabled.
+const rbfSequence = wire.MaxTxInSequenceNum - 2
+
+type BumpFeeParams struct {
+	// WIF-format keys which signed the inputs of the transaction.
+	// The change is recognised as the output paying back to one of them.
+	PrivateKeys []string
+	// Bitcoin address of the change, if the transaction was created with CreateParams.ChangeAddress.
+	ChangeAddress string
+	// defaults to netchain.MainNet.
+	Net netchain.Net
+	// Fetches more UTXOs of the keys when the change can't cover the new fee.
+	// defaults to addressinfo.FetchFromBlockcypher.
+	Fetch addressinfo.Fetch
+	// Fetches the transactions which the inputs spend. defaults to addressinfo.FetchRawTxFromBlockcypher.
+	FetchRawTx addressinfo.FetchRawTx
+	// In sat/vB, defaults to DefaultIncrementalRelayFee.
+	IncrementalRelayFee int64
+
+	changeScript []byte
+}
+
+// BumpFee rebuilds the transaction signaling replace-by-fee with the new fee rate in sat/vB.
+// The outputs stay the same, the extra fee is taken from the change or from new confirmed inputs if the change isn't enough.
+// Following BIP125, the replacement pays the fee of the original plus the incremental relay fee for its own size.
+func BumpFee(rawTx string, newFeeRate int64, params BumpFeeParams) (string, error) {
+	params, err := checkBumpFeeParams(params)
+	if err != nil {
+		return "", err
+	}
+	orig, err := hexDecodeTx(rawTx)
+	if err != nil {
+		return "", err
+	}
+	if !signalsRBF(orig) {
+		return "", fmt.Errorf("transaction %s doesn't signal replace-by-fee", orig.TxHash())
+	}
+	keys, err := toPkInfosOfAllTypes(params.PrivateKeys, params.Net)
+	if err != nil {
+		return "", err
+	}
+
+	var inputs []address
+	var inputsValue int64
+	for i, in := range orig.TxIn {
+		prevTx, err := fetchPrevTx(in.PreviousOutPoint, params.FetchRawTx, params.Net)
+		if err != nil {
+			return "", err
+		}
+		prevOut := prevTx.TxOut[in.PreviousOutPoint.Index]
+		info, ok := findKeyOfScript(keys, prevOut.PkScript)
+		if !ok {
+			return "", fmt.Errorf("input %d can't be signed with the private keys", i)
+		}
+		inputs = append(inputs, toSingleUTXOAddress(in.PreviousOutPoint, prevOut, info))
+		inputsValue += prevOut.Value
+	}
+	origFee := inputsValue - sumOutputs(orig.TxOut)
+	origVSize := virtualSize(orig)
+	if newFeeRate*origVSize <= origFee {
+		return "", fmt.Errorf("new fee rate must be higher than the current %.1f sat/vB", float64(origFee)/float64(origVSize))
+	}
+
+	changeIdx := -1
+	for i, out := range orig.TxOut {
+		_, ok := findKeyOfScript(keys, out.PkScript)
+		if params.changeScript != nil {
+			ok = bytes.Equal(out.PkScript, params.changeScript)
+		}
+		if ok {
+			changeIdx = i
+			break
+		}
+	}
+	changeScript := inputs[0].pkInfo.pkScript
+	var payments []*wire.TxOut
+	for i, out := range orig.TxOut {
+		if i == changeIdx {
+			changeScript = out.PkScript
+			continue
+		}
+		payments = append(payments, out)
+	}
+
+	var candidates []address
+	var candidatesFetched bool
+	fee := bip125Fee(newFeeRate, origFee, origVSize, params.IncrementalRelayFee)
+	// signatures change the size, the fee is adjusted until it covers the signed transaction
+	for attempt := 0; attempt < 10; attempt++ {
+		for inputsValue-sumOutputs(payments) < fee {
+			if !candidatesFetched {
+				candidates, err = fetchBumpFeeCandidates(orig, inputs, params)
+				if err != nil {
+					return "", err
+				}
+				candidatesFetched = true
+			}
+			if len(candidates) == 0 {
+				return "", &InsufficientFundsError{Needed: sumOutputs(payments) + fee, Available: inputsValue}
+			}
+			inputs = append(inputs, candidates[0])
+			inputsValue += candidates[0].Balance
+			candidates = candidates[1:]
+		}
+		change := inputsValue - sumOutputs(payments) - fee
+
+		tx := wire.NewMsgTx(orig.Version)
+		tx.LockTime = orig.LockTime
+		for i, input := range inputs {
+			sequence := uint32(rbfSequence)
+			if i < len(orig.TxIn) {
+				sequence = orig.TxIn[i].Sequence
+			}
+			err := addInputs(tx, input.UTXOs, sequence)
+			if err != nil {
+				return "", err
+			}
+		}
+		changeOut := wire.NewTxOut(change, changeScript)
+		for i, out := range payments {
+			if i == changeIdx {
+				addChange(tx, changeOut)
+			}
+			tx.AddTxOut(wire.NewTxOut(out.Value, out.PkScript))
+		}
+		if changeIdx == -1 || changeIdx >= len(payments) {
+			addChange(tx, changeOut)
+		}
+
+		err := signTx(tx, inputs)
+		if err != nil {
+			return "", err
+		}
+		required := bip125Fee(newFeeRate, origFee, virtualSize(tx), params.IncrementalRelayFee)
+		if inputsValue-sumOutputs(tx.TxOut) >= required {
+			return hexEncodeTx(tx)
+		}
+		fee = required
+	}
+	return "", fmt.Errorf("couldn't fit the fee of the replacement")
+}
+
+func checkBumpFeeParams(p BumpFeeParams) (BumpFeeParams, error) {
+	if len(p.PrivateKeys) == 0 {
+		return BumpFeeParams{}, fmt.Errorf("must specify PrivateKeys")
+	}
+	if p.Net == "" {
+		p.Net = netchain.MainNet
+	}
+	if err := checkNet(p.Net); err != nil {
+		return BumpFeeParams{}, err
+	}
+	if p.ChangeAddress != "" {
+		changeScript, err := addressToPkScript(p.ChangeAddress, p.Net)
+		if err != nil {
+			return BumpFeeParams{}, err
+		}
+		p.changeScript = changeScript
+	}
+	if p.Fetch == nil {
+		p.Fetch = addressinfo.FetchFromBlockcypher
+	}
+	if p.FetchRawTx == nil {
+		p.FetchRawTx = addressinfo.FetchRawTxFromBlockcypher
+	}
+	if p.IncrementalRelayFee == 0 {
+		p.IncrementalRelayFee = DefaultIncrementalRelayFee
+	}
+	return p, nil
+}
+
+// bip125Fee is the minimum fee of the replacement of the given virtual size.
+func bip125Fee(feeRate, origFee, vsize, incrementalRelayFee int64) int64 {
+	fee := feeRate * vsize
+	if minFee := origFee + incrementalRelayFee*vsize; fee < minFee {
+		fee = minFee
+	}
+	return fee
+}
+
+// addChange skips the change below the dust limit, leaving it to the miners.
+func addChange(tx *wire.MsgTx, change *wire.TxOut) {
+	if !isDust(change.Value, change.PkScript, DefaultDustRelayFee) {
+		tx.AddTxOut(change)
+	}
+}
+
+// fetchBumpFeeCandidates returns the UTXOs of the keys of the inputs which the replacement can spend.
+func fetchBumpFeeCandidates(orig *wire.MsgTx, inputs []address, params BumpFeeParams) ([]address, error) {
+	spent := make(map[string]bool)
+	for _, in := range inputs {
+		spent[outPointKey(in.UTXOs[0])] = true
+	}
+	fetched := make(map[string]bool)
+	var candidates []address
+	for _, in := range inputs {
+		if fetched[in.pkInfo.address] {
+			continue
+		}
+		fetched[in.pkInfo.address] = true
+		addr, err := params.Fetch(in.pkInfo.address, params.Net)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range addr.UTXOs {
+			// the outputs of the replaced transaction disappear with it
+			if u.TxID == orig.TxHash().String() || spent[outPointKey(u)] {
+				continue
+			}
+			// BIP125 rule 2, the replacement can't add unconfirmed inputs
+			if !isSpendable(u, 1) {
+				continue
+			}
+			candidates = append(candidates, address{Address: addressinfo.Address{Balance: u.Balance, UTXOs: []addressinfo.UTXO{u}}, pkInfo: in.pkInfo})
+		}
+	}
+	return candidates, nil
+}
+
+func outPointKey(u addressinfo.UTXO) string {
+	return fmt.Sprintf("%s:%d", u.TxID, u.TxOutIdx)
+}
+
+func toSingleUTXOAddress(outPoint wire.OutPoint, prevOut *wire.TxOut, info privateKeyInfo) address {
+	utxo := addressinfo.UTXO{
+		TxID:     outPoint.Hash.String(),
+		Pbscript: hex.EncodeToString(prevOut.PkScript),
+		Balance:  prevOut.Value,
+		TxOutIdx: int(outPoint.Index),
+	}
+	return address{Address: addressinfo.Address{Balance: utxo.Balance, UTXOs: []addressinfo.UTXO{utxo}}, pkInfo: info}
+}
+
+func signalsRBF(tx *wire.MsgTx) bool {
+	for _, in := range tx.TxIn {
+		if in.Sequence <= rbfSequence {
+			return true
+		}
+	}
+	return false
+}
+
+func sumOutputs(outs []*wire.TxOut) (sum int64) {
+	for _, out := range outs {
+		sum += out.Value
+	}
+	return
+}
+
+// virtualSize is the size in vbytes with the witness discount of BIP141.
+func virtualSize(tx *wire.MsgTx) int64 {
+	return (txWeight(tx) + 3) / 4
+}
+
+// txWeight counts a non-witness byte as 4 weight units and a witness byte as 1.
+func txWeight(tx *wire.MsgTx) int64 {
+	return int64(tx.SerializeSizeStripped()*3 + tx.SerializeSize())
+}
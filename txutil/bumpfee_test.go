@@ -0,0 +1,181 @@
+package txutil
+
+import (
+	"encoding/hex"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/glossd/btc/addressinfo"
+	"github.com/glossd/btc/netchain"
+	"github.com/glossd/btc/wallet"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCreate_RBF(t *testing.T) {
+	rawTx, err := Create(CreateParams{
+		PrivateKey:  privateKey1,
+		Destination: destination2,
+		Amount:      5e5,
+		RBF:         true,
+		Fetch:       addressinfo.FetchMock,
+		Net:         netchain.TestNet,
+	})
+	assert.Nil(t, err)
+	tx := decodeTx(t, rawTx)
+	assert.EqualValues(t, 0xfffffffd, tx.TxIn[0].Sequence)
+	assert.True(t, signalsRBF(tx))
+}
+
+func TestBumpFee(t *testing.T) {
+	segwitAddr, err := wallet.AddressFromPrivateKeyWithType(privateKey2, netchain.TestNet, wallet.P2WPKH)
+	assert.Nil(t, err)
+	fetch, fetchRawTx := fetchMockWithPrevTxs(t)
+	createWithFetch := func(amount int64, rbf bool, fetch addressinfo.Fetch) string {
+		rawTx, err := Create(CreateParams{
+			PrivateKey:  privateKey2,
+			AddressType: wallet.P2WPKH,
+			Destination: destination1,
+			Amount:      amount,
+			MinerFee:    1000,
+			RBF:         rbf,
+			Fetch:       fetch,
+			Net:         netchain.TestNet,
+		})
+		assert.Nil(t, err)
+		return rawTx
+	}
+	create := func(amount int64, rbf bool) string {
+		return createWithFetch(amount, rbf, fetch)
+	}
+	bumpParams := BumpFeeParams{
+		PrivateKeys: []string{privateKey2},
+		Net:         netchain.TestNet,
+		Fetch:       fetch,
+		FetchRawTx:  fetchRawTx,
+	}
+
+	t.Run("FromChange", func(t *testing.T) {
+		var amount int64 = 5e5
+		orig := decodeTx(t, create(amount, true))
+		rawTx, err := BumpFee(hexTx(t, orig), 20, bumpParams)
+		assert.Nil(t, err)
+
+		tx := decodeTx(t, rawTx)
+		assert.EqualValues(t, orig.TxIn[0].PreviousOutPoint, tx.TxIn[0].PreviousOutPoint)
+		assert.EqualValues(t, 1, len(tx.TxIn))
+		assert.EqualValues(t, 2, len(tx.TxOut))
+		// the replacement keeps the order of the outputs
+		for i := range orig.TxOut {
+			assert.EqualValues(t, orig.TxOut[i].PkScript, tx.TxOut[i].PkScript)
+		}
+		assert.EqualValues(t, amount, outputTo(t, tx, addressPkScript(t, destination1)).Value)
+		segwitScript := addressPkScript(t, segwitAddr)
+		assert.Less(t, outputTo(t, tx, segwitScript).Value, outputTo(t, orig, segwitScript).Value)
+		fee := addressinfo.MockAddressBalance - sumOutputs(tx.TxOut)
+		assert.GreaterOrEqual(t, fee, 20*virtualSize(tx))
+		assert.GreaterOrEqual(t, fee, 1000+virtualSize(tx))
+		verifyInput(t, tx, 0, addressPkScript(t, segwitAddr), addressinfo.MockAddressBalance)
+	})
+	t.Run("AddingInput", func(t *testing.T) {
+		// the change of 500 satoshi can't pay the new fee
+		orig := decodeTx(t, create(addressinfo.MockAddressBalance-1500, true))
+		rawTx, err := BumpFee(hexTx(t, orig), 20, bumpParams)
+		assert.Nil(t, err)
+
+		tx := decodeTx(t, rawTx)
+		assert.EqualValues(t, 2, len(tx.TxIn))
+		assert.EqualValues(t, 2, len(tx.TxOut))
+		assert.EqualValues(t, addressinfo.MockAddressBalance-1500, outputTo(t, tx, addressPkScript(t, destination1)).Value)
+		assert.NotNil(t, outputTo(t, tx, addressPkScript(t, segwitAddr)))
+		for i := range tx.TxIn {
+			verifyInput(t, tx, i, addressPkScript(t, segwitAddr), addressinfo.MockAddressBalance)
+		}
+	})
+	t.Run("ChangeAddress", func(t *testing.T) {
+		var amount int64 = 5e5
+		rawTx, err := Create(CreateParams{
+			PrivateKey:    privateKey2,
+			AddressType:   wallet.P2WPKH,
+			Destination:   destination1,
+			Amount:        amount,
+			ChangeAddress: destination3,
+			MinerFee:      1000,
+			RBF:           true,
+			Fetch:         fetch,
+			Net:           netchain.TestNet,
+		})
+		assert.Nil(t, err)
+		orig := decodeTx(t, rawTx)
+		params := bumpParams
+		params.ChangeAddress = destination3
+		bumped, err := BumpFee(rawTx, 20, params)
+		assert.Nil(t, err)
+
+		// the fee comes from the change to the fresh address instead of new inputs
+		tx := decodeTx(t, bumped)
+		assert.EqualValues(t, 1, len(tx.TxIn))
+		assert.EqualValues(t, amount, outputTo(t, tx, addressPkScript(t, destination1)).Value)
+		changeScript := addressPkScript(t, destination3)
+		assert.Less(t, outputTo(t, tx, changeScript).Value, outputTo(t, orig, changeScript).Value)
+	})
+	t.Run("UnconfirmedInput", func(t *testing.T) {
+		unconfirmed := func(address string, net netchain.Net) (addressinfo.Address, error) {
+			addr, err := fetch(address, net)
+			for i := range addr.UTXOs {
+				addr.UTXOs[i].Confirmations = 0
+			}
+			return addr, err
+		}
+		orig := createWithFetch(addressinfo.MockAddressBalance-1500, true, unconfirmed)
+		params := bumpParams
+		params.Fetch = unconfirmed
+		_, err := BumpFee(orig, 20, params)
+		assert.NotNil(t, err, "BIP125 forbids adding unconfirmed inputs")
+	})
+	t.Run("Validation", func(t *testing.T) {
+		_, err := BumpFee(create(5e5, false), 20, bumpParams)
+		assert.NotNil(t, err, "doesn't signal RBF")
+
+		_, err = BumpFee(create(5e5, true), 1, bumpParams)
+		assert.NotNil(t, err, "lower fee rate")
+
+		_, err = BumpFee(create(5e5, true), 20, BumpFeeParams{PrivateKeys: []string{privateKey3}, Net: netchain.TestNet, Fetch: fetch, FetchRawTx: fetchRawTx})
+		assert.NotNil(t, err, "foreign inputs")
+	})
+}
+
+// fetchMockWithPrevTxs gives every address two confirmed UTXOs of MockAddressBalance,
+// which are the outputs of the transaction returned by the FetchRawTx.
+func fetchMockWithPrevTxs(t *testing.T) (addressinfo.Fetch, addressinfo.FetchRawTx) {
+	prevTxs := make(map[string]*wire.MsgTx)
+	fetch := func(address string, net netchain.Net) (addressinfo.Address, error) {
+		prevTx := wire.NewMsgTx(wire.TxVersion)
+		prevTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, []byte(address), nil))
+		script := addressPkScript(t, address)
+		prevTx.AddTxOut(wire.NewTxOut(addressinfo.MockAddressBalance, script))
+		prevTx.AddTxOut(wire.NewTxOut(addressinfo.MockAddressBalance, script))
+		prevTxs[prevTx.TxHash().String()] = prevTx
+
+		var result addressinfo.Address
+		for i := range prevTx.TxOut {
+			result.UTXOs = append(result.UTXOs, addressinfo.UTXO{
+				TxID:          prevTx.TxHash().String(),
+				Pbscript:      hex.EncodeToString(script),
+				Balance:       addressinfo.MockAddressBalance,
+				TxOutIdx:      i,
+				Confirmations: 6,
+			})
+			result.Balance += addressinfo.MockAddressBalance
+		}
+		return result, nil
+	}
+	fetchRawTx := func(txID string, net netchain.Net) (string, error) {
+		return hexEncodeTx(prevTxs[txID])
+	}
+	return fetch, fetchRawTx
+}
+
+func hexTx(t *testing.T, tx *wire.MsgTx) string {
+	rawTx, err := hexEncodeTx(tx)
+	assert.Nil(t, err)
+	return rawTx
+}
@@ -0,0 +1,433 @@
+package txutil
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/glossd/btc/addressinfo"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// DefaultLongTermFeeRate in sat/vB is the fee rate the UTXOs are expected to be spent at later,
+// the default consolidation fee rate of Bitcoin Core.
+const DefaultLongTermFeeRate = 10
+
+// The limit of the combinations BranchAndBound tries, the same as Bitcoin Core's.
+const bnbMaxTries = 100000
+
+// CoinSelector chooses the UTXOs to spend.
+type CoinSelector interface {
+	Select(coins []Coin, params SelectionParams) (CoinSelection, error)
+}
+
+// Coin is a UTXO with the fees of spending it.
+type Coin struct {
+	addressinfo.UTXO
+	// The fee of the input spending the UTXO at the fee rate of the transaction.
+	Fee int64
+	// The fee of the input spending the UTXO at the long-term fee rate.
+	LongTermFee int64
+
+	pkInfo privateKeyInfo
+}
+
+// EffectiveValue is what the UTXO adds to the transaction after paying for its input.
+func (c Coin) EffectiveValue() int64 {
+	return c.Balance - c.Fee
+}
+
+type SelectionParams struct {
+	// The amount to send plus the fee of the transaction without the inputs and the change.
+	Target int64
+	// The fee of the change output.
+	ChangeFee int64
+	// The fee of the change output and of spending it later at the long-term fee rate.
+	CostOfChange int64
+	// The smallest change worth its output.
+	MinChange int64
+}
+
+type CoinSelection struct {
+	Coins []Coin
+	// Sum of the balances of the coins.
+	Balance int64
+	// Whether the coins leave the change, otherwise the excess goes to the miners.
+	Change bool
+	// Waste metric of Bitcoin Core, the lower the better. It's the extra fee of spending the coins now
+	// rather than at the long-term fee rate plus either the cost of the change or the excess given to the miners.
+	Waste int64
+}
+
+func newCoinSelection(coins []Coin, p SelectionParams) CoinSelection {
+	s := CoinSelection{Coins: coins}
+	var effectiveValue int64
+	for _, c := range coins {
+		s.Balance += c.Balance
+		effectiveValue += c.EffectiveValue()
+		s.Waste += c.Fee - c.LongTermFee
+	}
+	excess := effectiveValue - p.Target
+	s.Change = excess-p.ChangeFee >= p.MinChange
+	if s.Change {
+		s.Waste += p.CostOfChange
+	} else {
+		s.Waste += excess
+	}
+	return s
+}
+
+// BranchAndBound searches for the coins paying the target without the change,
+// with the excess not above the cost of the change. Of such combinations it picks the one with the least waste.
+// It fails if there is no such combination.
+type BranchAndBound struct{}
+
+func (BranchAndBound) Select(coins []Coin, p SelectionParams) (CoinSelection, error) {
+	pool := sortedByEffectiveValue(positiveCoins(coins))
+	// rest[i] is the sum of the effective values of pool[i:]
+	rest := make([]int64, len(pool)+1)
+	for i := len(pool) - 1; i >= 0; i-- {
+		rest[i] = rest[i+1] + pool[i].EffectiveValue()
+	}
+
+	var best []Coin
+	bestWaste := int64(math.MaxInt64)
+	var selected []Coin
+	var tries int
+	var search func(i int, value, waste int64)
+	search = func(i int, value, waste int64) {
+		tries++
+		if tries > bnbMaxTries || value > p.Target+p.CostOfChange {
+			return
+		}
+		if value >= p.Target {
+			if w := waste + value - p.Target; w < bestWaste {
+				best = append([]Coin{}, selected...)
+				bestWaste = w
+			}
+			return
+		}
+		if i == len(pool) || value+rest[i] < p.Target {
+			return
+		}
+		c := pool[i]
+		selected = append(selected, c)
+		search(i+1, value+c.EffectiveValue(), waste+c.Fee-c.LongTermFee)
+		selected = selected[:len(selected)-1]
+		// leaving out the coin, the equal coins after it would only repeat the combinations with it
+		next := i + 1
+		for next < len(pool) && pool[next].EffectiveValue() == c.EffectiveValue() && pool[next].Fee == c.Fee {
+			next++
+		}
+		search(next, value, waste)
+	}
+	search(0, 0, 0)
+
+	if best == nil {
+		return CoinSelection{}, fmt.Errorf("branch and bound found no coins paying %d without the change", p.Target)
+	}
+	return newCoinSelection(best, p), nil
+}
+
+// Knapsack picks the coin equal to the target if there is one, otherwise it approximates
+// the smallest subset of the coins below the target which leaves the change,
+// preferring the smallest single larger coin if it's closer. Randomized like in Bitcoin Core.
+type Knapsack struct {
+	// Iterations of the approximation, defaults to 1000.
+	Iterations int
+}
+
+func (k Knapsack) Select(coins []Coin, p SelectionParams) (CoinSelection, error) {
+	iterations := k.Iterations
+	if iterations == 0 {
+		iterations = 1000
+	}
+	targetWithChange := p.Target + p.ChangeFee + p.MinChange
+
+	var lowers []Coin
+	var lowersValue int64
+	var lowestLarger *Coin
+	for _, c := range positiveCoins(coins) {
+		c := c
+		switch {
+		case c.EffectiveValue() == p.Target:
+			return newCoinSelection([]Coin{c}, p), nil
+		case c.EffectiveValue() < targetWithChange:
+			lowers = append(lowers, c)
+			lowersValue += c.EffectiveValue()
+		case lowestLarger == nil || c.EffectiveValue() < lowestLarger.EffectiveValue():
+			lowestLarger = &c
+		}
+	}
+
+	if lowersValue == p.Target {
+		return newCoinSelection(lowers, p), nil
+	}
+	if lowersValue < p.Target {
+		if lowestLarger == nil {
+			return CoinSelection{}, &InsufficientFundsError{Needed: p.Target, Available: lowersValue}
+		}
+		return newCoinSelection([]Coin{*lowestLarger}, p), nil
+	}
+
+	lowers = sortedByEffectiveValue(lowers)
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	best, bestValue := approximateBestSubset(rnd, lowers, p.Target, iterations)
+	if bestValue != p.Target && lowersValue >= targetWithChange {
+		best, bestValue = approximateBestSubset(rnd, lowers, targetWithChange, iterations)
+	}
+	if lowestLarger != nil && ((bestValue != p.Target && bestValue < targetWithChange) || lowestLarger.EffectiveValue() <= bestValue) {
+		return newCoinSelection([]Coin{*lowestLarger}, p), nil
+	}
+	return newCoinSelection(best, p), nil
+}
+
+// approximateBestSubset randomly includes the coins, sorted from the largest, to find the smallest sum reaching the target.
+func approximateBestSubset(rnd *rand.Rand, coins []Coin, target int64, iterations int) ([]Coin, int64) {
+	bestIncluded := make([]bool, len(coins))
+	for i := range bestIncluded {
+		bestIncluded[i] = true
+	}
+	var bestValue int64
+	for _, c := range coins {
+		bestValue += c.EffectiveValue()
+	}
+
+	included := make([]bool, len(coins))
+	for rep := 0; rep < iterations && bestValue != target; rep++ {
+		for i := range included {
+			included[i] = false
+		}
+		var value int64
+		reachedTarget := false
+		// the first pass includes the coins randomly, the second pass includes the rest
+		for pass := 0; pass < 2 && !reachedTarget; pass++ {
+			for i, c := range coins {
+				if (pass == 0 && rnd.Intn(2) == 0) || (pass == 1 && included[i]) {
+					continue
+				}
+				value += c.EffectiveValue()
+				included[i] = true
+				if value >= target {
+					reachedTarget = true
+					if value < bestValue {
+						bestValue = value
+						copy(bestIncluded, included)
+					}
+					// trying to reach the target without this coin
+					value -= c.EffectiveValue()
+					included[i] = false
+				}
+			}
+		}
+	}
+
+	var best []Coin
+	for i, c := range coins {
+		if bestIncluded[i] {
+			best = append(best, c)
+		}
+	}
+	return best, bestValue
+}
+
+// LargestFirst spends the coins of the largest effective value first, which keeps the number of inputs low.
+type LargestFirst struct{}
+
+func (LargestFirst) Select(coins []Coin, p SelectionParams) (CoinSelection, error) {
+	return accumulate(sortedByEffectiveValue(positiveCoins(coins)), p)
+}
+
+// OldestFirst spends the coins with the most confirmations first. The coins with as many confirmations
+// are spent from the end of the list, as Fetch lists the newest UTXOs of an address first like Blockcypher does.
+type OldestFirst struct{}
+
+func (OldestFirst) Select(coins []Coin, p SelectionParams) (CoinSelection, error) {
+	pool := positiveCoins(coins)
+	for i, j := 0, len(pool)-1; i < j; i, j = i+1, j-1 {
+		pool[i], pool[j] = pool[j], pool[i]
+	}
+	sort.SliceStable(pool, func(i, j int) bool {
+		return pool[i].Confirmations > pool[j].Confirmations
+	})
+	return accumulate(pool, p)
+}
+
+// accumulate takes the coins in order until they pay the target.
+func accumulate(coins []Coin, p SelectionParams) (CoinSelection, error) {
+	var value int64
+	for i, c := range coins {
+		value += c.EffectiveValue()
+		if value >= p.Target {
+			return newCoinSelection(coins[:i+1], p), nil
+		}
+	}
+	return CoinSelection{}, &InsufficientFundsError{Needed: p.Target, Available: value}
+}
+
+// positiveCoins returns a copy of the coins without those costing more to spend than they are worth.
+func positiveCoins(coins []Coin) []Coin {
+	var result []Coin
+	for _, c := range coins {
+		if c.EffectiveValue() > 0 {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+func sortedByEffectiveValue(coins []Coin) []Coin {
+	sort.SliceStable(coins, func(i, j int) bool {
+		return coins[i].EffectiveValue() > coins[j].EffectiveValue()
+	})
+	return coins
+}
+
+// SelectCoins returns the coins the CoinSelector of the params chooses, with the waste metric to compare the selectors.
+func SelectCoins(params CreateParams) (CoinSelection, error) {
+	params, err := checkCreateParams(params)
+	if err != nil {
+		return CoinSelection{}, err
+	}
+	if params.CoinSelector == nil {
+		return CoinSelection{}, fmt.Errorf("must specify CoinSelector")
+	}
+	if params.AutoMinerFee {
+		params, err = fetchFeeRate(params)
+		if err != nil {
+			return CoinSelection{}, err
+		}
+	}
+	coins, err := fetchCoins(params)
+	if err != nil {
+		return CoinSelection{}, err
+	}
+	return params.CoinSelector.Select(coins, newSelectionParams(params, coins))
+}
+
+// fetchCoins returns the UTXOs of all the keys.
+func fetchCoins(params CreateParams) ([]Coin, error) {
+	var coins []Coin
+	for _, pkInfo := range params.pkInfos {
+		addr, err := params.Fetch(pkInfo.address, params.Net)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range addr.UTXOs {
+			coin := Coin{UTXO: u, pkInfo: pkInfo}
+			// the destinations paying the fee leave the full value of the coins to the sender
+			if params.FeeRate > 0 && !params.subtractsFee() {
+				weight, _ := inputWeight(utxoWithKey{UTXO: u, pkInfo: pkInfo, pkScript: pkInfo.pkScript})
+				coin.Fee = feeOfWeight(params.FeeRate, weight)
+				coin.LongTermFee = feeOfWeight(params.LongTermFeeRate, weight)
+			}
+			coins = append(coins, coin)
+		}
+	}
+	return coins, nil
+}
+
+// newSelectionParams describes the transaction without the inputs to the CoinSelector.
+// With the fixed MinerFee or the fee paid by the destinations the inputs and the change don't cost anything extra.
+func newSelectionParams(params CreateParams, coins []Coin) SelectionParams {
+	changeScript := params.changeScript
+	p := SelectionParams{Target: params.fullCost(), MinChange: DustLimit(changeScript, params.DustRelayFee)}
+	if params.FeeRate == 0 || params.subtractsFee() {
+		return p
+	}
+
+	weight := txOverheadWeight + (wire.VarIntSerializeSize(uint64(len(coins)))+wire.VarIntSerializeSize(uint64(len(params.destInfos)+1)))*4
+	for _, info := range params.destInfos {
+		weight += outputWeight(info.pkScript)
+	}
+	for _, c := range coins {
+		if _, witness := inputWeight(utxoWithKey{pkScript: c.pkInfo.pkScript, pkInfo: c.pkInfo}); witness {
+			weight += segWitMarkerWeight
+			break
+		}
+	}
+	changeWeight := outputWeight(changeScript)
+	// the change of an own key, e.g. of the multisig, costs as much to spend as its coins
+	spendChange := utxoWithKey{pkScript: changeScript}
+	for _, info := range params.pkInfos {
+		if bytes.Equal(info.pkScript, changeScript) {
+			spendChange.pkInfo = info
+			break
+		}
+	}
+	spendChangeWeight, _ := inputWeight(spendChange)
+
+	p.Target = params.fullAmount() + feeOfWeight(params.FeeRate, weight)
+	p.ChangeFee = feeOfWeight(params.FeeRate, changeWeight)
+	p.CostOfChange = p.ChangeFee + feeOfWeight(params.LongTermFeeRate, spendChangeWeight)
+	return p
+}
+
+func feeOfWeight(feeRate int64, weight int) int64 {
+	return (feeRate*int64(weight) + 3) / 4
+}
+
+// selectCoinsForTx fetches the coins the CoinSelector chooses and sets the MinerFee of the transaction spending them.
+func selectCoinsForTx(params CreateParams) (CreateParams, []address, error) {
+	coins, err := fetchCoins(params)
+	if err != nil {
+		return CreateParams{}, nil, err
+	}
+	selection, err := params.CoinSelector.Select(coins, newSelectionParams(params, coins))
+	if err != nil {
+		return CreateParams{}, nil, err
+	}
+
+	var addrs []address
+	idxOfAddr := make(map[string]int)
+	for _, c := range selection.Coins {
+		idx, ok := idxOfAddr[c.pkInfo.address]
+		if !ok {
+			idx = len(addrs)
+			idxOfAddr[c.pkInfo.address] = idx
+			addrs = append(addrs, address{pkInfo: c.pkInfo})
+		}
+		addrs[idx].UTXOs = append(addrs[idx].UTXOs, c.UTXO)
+		addrs[idx].Balance += c.Balance
+	}
+	if params.FeeRate == 0 {
+		return params, addrs, nil
+	}
+	if params.subtractsFee() {
+		// the change is the excess of the coins whatever the fee is
+		tx, err := buildUnsignedTx(params, addrs)
+		if err != nil {
+			return CreateParams{}, nil, err
+		}
+		vsize, err := estimateVSize(tx, addrs)
+		if err != nil {
+			return CreateParams{}, nil, err
+		}
+		params.MinerFee = params.FeeRate * vsize
+		return params, addrs, nil
+	}
+
+	// the estimation of the whole transaction is more precise than the sum of the fees of its parts
+	params.MinerFee = selection.Balance - params.fullAmount()
+	tx, err := buildUnsignedTx(params, addrs)
+	if err != nil {
+		return CreateParams{}, nil, err
+	}
+	vsize, err := estimateVSize(tx, addrs)
+	if err != nil {
+		return CreateParams{}, nil, err
+	}
+	if params.MinerFee < params.FeeRate*vsize {
+		return CreateParams{}, nil, &InsufficientFundsError{Needed: params.fullAmount() + params.FeeRate*vsize, Available: selection.Balance}
+	}
+	changeScript := params.changeScript
+	changeFee := feeOfWeight(params.FeeRate, outputWeight(changeScript))
+	if selection.Change && !isDust(params.MinerFee-params.FeeRate*vsize-changeFee, changeScript, params.DustRelayFee) {
+		params.MinerFee = params.FeeRate*vsize + changeFee
+	} else {
+		params.dustChange = params.MinerFee - params.FeeRate*vsize
+	}
+	return params, addrs, nil
+}
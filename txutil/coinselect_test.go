@@ -0,0 +1,202 @@
+package txutil
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/glossd/btc/addressinfo"
+	"github.com/glossd/btc/netchain"
+	"github.com/glossd/btc/wallet"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestBranchAndBound(t *testing.T) {
+	coins := coinsOf(1e5, 2e5, 3e5, 5e5)
+	selection, err := BranchAndBound{}.Select(coins, SelectionParams{Target: 5e5 - 200, CostOfChange: 300, MinChange: 546})
+	assert.Nil(t, err)
+	// the single coin wastes less than two, since the fee rate is above the long-term one
+	assert.EqualValues(t, 1, len(selection.Coins))
+	assert.EqualValues(t, 5e5, selection.Balance)
+	assert.False(t, selection.Change)
+	assert.EqualValues(t, 200-100, selection.Waste)
+
+	_, err = BranchAndBound{}.Select(coins, SelectionParams{Target: 2.5e5, CostOfChange: 300, MinChange: 546})
+	assert.NotNil(t, err, "no combination without the change")
+}
+
+func TestKnapsack(t *testing.T) {
+	p := SelectionParams{ChangeFee: 300, CostOfChange: 1000, MinChange: 546}
+	t.Run("EqualCoin", func(t *testing.T) {
+		p := p
+		p.Target = 3e5 - 200
+		selection, err := Knapsack{}.Select(coinsOf(1e5, 2e5, 3e5, 5e5), p)
+		assert.Nil(t, err)
+		assert.EqualValues(t, 3e5, selection.Balance)
+		assert.False(t, selection.Change)
+	})
+	t.Run("LowestLarger", func(t *testing.T) {
+		p := p
+		p.Target = 4e5
+		selection, err := Knapsack{}.Select(coinsOf(1e5, 2e5, 5e5, 9e5), p)
+		assert.Nil(t, err)
+		assert.EqualValues(t, []int64{5e5}, balancesOf(selection.Coins))
+		assert.True(t, selection.Change)
+	})
+	t.Run("Subset", func(t *testing.T) {
+		p := p
+		p.Target = 4.5e5
+		selection, err := Knapsack{}.Select(coinsOf(1e5, 2e5, 3e5, 2e6), p)
+		assert.Nil(t, err)
+		assert.EqualValues(t, 5e5, selection.Balance)
+		assert.True(t, selection.Change)
+	})
+	t.Run("NotEnough", func(t *testing.T) {
+		p := p
+		p.Target = 1e6
+		_, err := Knapsack{}.Select(coinsOf(1e5, 2e5), p)
+		assert.NotNil(t, err)
+	})
+}
+
+func TestLargestFirst(t *testing.T) {
+	selection, err := LargestFirst{}.Select(coinsOf(1e5, 5e5, 3e5), SelectionParams{Target: 6e5, MinChange: 546})
+	assert.Nil(t, err)
+	assert.EqualValues(t, []int64{5e5, 3e5}, balancesOf(selection.Coins))
+	assert.True(t, selection.Change)
+
+	_, err = LargestFirst{}.Select(coinsOf(1e5, 5e5, 3e5), SelectionParams{Target: 1e6})
+	assert.NotNil(t, err)
+}
+
+func TestOldestFirst(t *testing.T) {
+	// listed from the newest
+	selection, err := OldestFirst{}.Select(coinsOf(5e5, 3e5, 1e5), SelectionParams{Target: 2e5, MinChange: 546})
+	assert.Nil(t, err)
+	assert.EqualValues(t, []int64{1e5, 3e5}, balancesOf(selection.Coins))
+
+	coins := coinsOf(5e5, 3e5, 1e5)
+	coins[0].Confirmations = 10
+	coins[1].Confirmations = 20
+	selection, err = OldestFirst{}.Select(coins, SelectionParams{Target: 6e5, MinChange: 546})
+	assert.Nil(t, err)
+	assert.EqualValues(t, []int64{3e5, 5e5}, balancesOf(selection.Coins))
+}
+
+func TestSelectCoins(t *testing.T) {
+	params := CreateParams{
+		PrivateKey:  privateKey2,
+		AddressType: wallet.P2WPKH,
+		Destination: destination1,
+		FeeRate:     20,
+		Fetch:       fetchMockOfBalances(4e5, 3e5, 2e5, 1e5),
+		Net:         netchain.TestNet,
+	}
+	// with one P2WPKH input and one P2PKH output of 113 vbytes the 4e5 coin leaves no change
+	params.Amount = 4e5 - 20*113
+
+	params.CoinSelector = BranchAndBound{}
+	bnb, err := SelectCoins(params)
+	assert.Nil(t, err)
+	assert.EqualValues(t, []int64{4e5}, balancesOf(bnb.Coins))
+	assert.False(t, bnb.Change)
+
+	params.CoinSelector = OldestFirst{}
+	oldest, err := SelectCoins(params)
+	assert.Nil(t, err)
+	assert.EqualValues(t, []int64{1e5, 2e5, 3e5}, balancesOf(oldest.Coins))
+	assert.True(t, oldest.Change)
+	assert.Less(t, bnb.Waste, oldest.Waste)
+}
+
+func TestCreate_CoinSelector(t *testing.T) {
+	segwitAddr, err := wallet.AddressFromPrivateKeyWithType(privateKey2, netchain.TestNet, wallet.P2WPKH)
+	assert.Nil(t, err)
+	for _, selector := range []CoinSelector{BranchAndBound{}, Knapsack{}, LargestFirst{}, OldestFirst{}} {
+		t.Run(fmt.Sprintf("%T", selector), func(t *testing.T) {
+			rawTx, err := Create(CreateParams{
+				PrivateKey:   privateKey2,
+				AddressType:  wallet.P2WPKH,
+				Destination:  destination1,
+				Amount:       4e5 - 20*113,
+				FeeRate:      20,
+				CoinSelector: selector,
+				Fetch:        fetchMockOfBalances(4e5, 3e5, 2e5, 1e5),
+				Net:          netchain.TestNet,
+			})
+			assert.Nil(t, err)
+			tx := decodeTx(t, rawTx)
+			var balance int64
+			for i, in := range tx.TxIn {
+				amount := int64(4-in.PreviousOutPoint.Index) * 1e5
+				balance += amount
+				verifyInput(t, tx, i, addressPkScript(t, segwitAddr), amount)
+			}
+			assert.EqualValues(t, 4e5-20*113, outputTo(t, tx, addressPkScript(t, destination1)).Value)
+			assert.GreaterOrEqual(t, balance-sumOutputs(tx.TxOut), 20*virtualSize(tx))
+		})
+	}
+	t.Run("MinerFee", func(t *testing.T) {
+		rawTx, err := Create(CreateParams{
+			PrivateKey:   privateKey2,
+			AddressType:  wallet.P2WPKH,
+			Destination:  destination1,
+			Amount:       5e5 - 1000,
+			MinerFee:     1000,
+			CoinSelector: BranchAndBound{},
+			Fetch:        fetchMockOfBalances(4e5, 3e5, 2e5, 1e5),
+			Net:          netchain.TestNet,
+		})
+		assert.Nil(t, err)
+		tx := decodeTx(t, rawTx)
+		assert.EqualValues(t, 2, len(tx.TxIn))
+		assert.EqualValues(t, 1, len(tx.TxOut))
+	})
+}
+
+func TestChooseUTXOs_KeepsOrder(t *testing.T) {
+	utxos := []addressinfo.UTXO{{Balance: 3}, {Balance: 1}, {Balance: 2}}
+	toSpend, balance, err := chooseUTXOs(utxos, 3)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 3, balance)
+	assert.EqualValues(t, 2, len(toSpend))
+	assert.EqualValues(t, []addressinfo.UTXO{{Balance: 3}, {Balance: 1}, {Balance: 2}}, utxos)
+}
+
+// coinsOf returns the coins costing 200 satoshi to spend now and 100 at the long-term fee rate.
+func coinsOf(balances ...int64) []Coin {
+	var coins []Coin
+	for i, b := range balances {
+		coins = append(coins, Coin{UTXO: addressinfo.UTXO{TxOutIdx: i, Balance: b}, Fee: 200, LongTermFee: 100})
+	}
+	return coins
+}
+
+func balancesOf(coins []Coin) []int64 {
+	var result []int64
+	for _, c := range coins {
+		result = append(result, c.Balance)
+	}
+	return result
+}
+
+// fetchMockOfBalances gives every address the UTXOs of the balances, the output index of each UTXO is its position.
+func fetchMockOfBalances(balances ...int64) addressinfo.Fetch {
+	return func(address string, net netchain.Net) (addressinfo.Address, error) {
+		script, err := addressToPkScript(address, net)
+		if err != nil {
+			return addressinfo.Address{}, err
+		}
+		var result addressinfo.Address
+		for i, b := range balances {
+			result.UTXOs = append(result.UTXOs, addressinfo.UTXO{
+				TxID:     chainhash.HashH([]byte(address)).String(),
+				Pbscript: hex.EncodeToString(script),
+				Balance:  b,
+				TxOutIdx: i,
+			})
+			result.Balance += b
+		}
+		return result, nil
+	}
+}
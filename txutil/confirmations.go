@@ -0,0 +1,36 @@
+package txutil
+
+import (
+	"github.com/glossd/btc/addressinfo"
+	"github.com/glossd/btc/netchain"
+)
+
+// requiredConfirmations returns how many confirmations the UTXO needs to be spent.
+func requiredConfirmations(u addressinfo.UTXO, minConfirmations int) int {
+	if u.IsCoinbase && minConfirmations < addressinfo.CoinbaseMaturity {
+		return addressinfo.CoinbaseMaturity
+	}
+	return minConfirmations
+}
+
+func isSpendable(u addressinfo.UTXO, minConfirmations int) bool {
+	return u.Confirmations >= requiredConfirmations(u, minConfirmations)
+}
+
+// fetchSpendable leaves out the UTXOs without enough confirmations and the immature coinbase UTXOs.
+func fetchSpendable(fetch addressinfo.Fetch, minConfirmations int) addressinfo.Fetch {
+	return func(address string, net netchain.Net) (addressinfo.Address, error) {
+		addr, err := fetch(address, net)
+		if err != nil {
+			return addressinfo.Address{}, err
+		}
+		var spendable addressinfo.Address
+		for _, u := range addr.UTXOs {
+			if isSpendable(u, minConfirmations) {
+				spendable.UTXOs = append(spendable.UTXOs, u)
+				spendable.Balance += u.Balance
+			}
+		}
+		return spendable, nil
+	}
+}
@@ -0,0 +1,87 @@
+package txutil
+
+import (
+	"errors"
+	"github.com/glossd/btc/addressinfo"
+	"github.com/glossd/btc/netchain"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// fetchMockOfUTXOs gives the address the UTXOs of 4e5, 3e5 and 2e5 with the set confirmations and coinbase flags.
+func fetchMockOfUTXOs(set func(utxos []addressinfo.UTXO)) addressinfo.Fetch {
+	return func(address string, net netchain.Net) (addressinfo.Address, error) {
+		addr, err := fetchMockOfBalances(4e5, 3e5, 2e5)(address, net)
+		if err != nil {
+			return addressinfo.Address{}, err
+		}
+		set(addr.UTXOs)
+		return addr, nil
+	}
+}
+
+func TestCreate_MinConfirmations(t *testing.T) {
+	params := CreateParams{
+		PrivateKey:  privateKey1,
+		Destination: destination2,
+		Amount:      1e5,
+		Net:         netchain.TestNet,
+		Fetch: fetchMockOfUTXOs(func(utxos []addressinfo.UTXO) {
+			utxos[0].Confirmations = 150
+			utxos[0].IsCoinbase = true
+			utxos[1].Confirmations = 3
+			utxos[2].Confirmations = 0
+		}),
+	}
+
+	t.Run("Unconfirmed", func(t *testing.T) {
+		tx := decodeTx(t, mustCreate(t, params))
+		// the smallest UTXO is spent first
+		assert.EqualValues(t, 1, len(tx.TxIn))
+		assert.EqualValues(t, 2, tx.TxIn[0].PreviousOutPoint.Index)
+	})
+	t.Run("Confirmed", func(t *testing.T) {
+		p := params
+		p.MinConfirmations = 1
+		tx := decodeTx(t, mustCreate(t, p))
+		assert.EqualValues(t, 1, len(tx.TxIn))
+		assert.EqualValues(t, 1, tx.TxIn[0].PreviousOutPoint.Index)
+	})
+	t.Run("NotEnough", func(t *testing.T) {
+		p := params
+		p.MinConfirmations = 6
+		p.Amount = 5e5
+		_, err := Create(p)
+		assert.True(t, errors.Is(err, ErrInsufficientFunds))
+	})
+}
+
+func TestCreate_ImmatureCoinbase(t *testing.T) {
+	params := CreateParams{
+		PrivateKey:  privateKey1,
+		Destination: destination2,
+		SendAll:     true,
+		Net:         netchain.TestNet,
+		Fetch: fetchMockOfUTXOs(func(utxos []addressinfo.UTXO) {
+			for i := range utxos {
+				utxos[i].Confirmations = 99
+			}
+			utxos[0].IsCoinbase = true
+		}),
+	}
+	tx := decodeTx(t, mustCreate(t, params))
+	assert.EqualValues(t, 2, len(tx.TxIn))
+	assert.EqualValues(t, 5e5-DefaultMinerFee, tx.TxOut[0].Value)
+
+	addr, err := params.Fetch(destination1, netchain.TestNet)
+	assert.Nil(t, err)
+	params.UTXOs = addr.UTXOs
+	_, err = Create(params)
+	assert.NotNil(t, err)
+}
+
+func mustCreate(t *testing.T, params CreateParams) string {
+	rawTx, err := Create(params)
+	assert.Nil(t, err)
+	return rawTx
+}
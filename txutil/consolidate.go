@@ -0,0 +1,158 @@
+package txutil
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/glossd/btc/addressinfo"
+	"github.com/glossd/btc/netchain"
+	"github.com/glossd/btc/wallet"
+)
+
+type ConsolidateParams struct {
+	// WIF-format keys of the wallets with many small UTXOs.
+	PrivateKeys []string
+	// Type of the addresses of the PrivateKeys, defaults to wallet.P2PKH.
+	AddressType wallet.AddressType
+	// Bitcoin address receiving the merged UTXOs, defaults to the address of the first private key.
+	Destination string
+	// In satoshi, only the UTXOs below the value are merged.
+	MaxUTXOValue int64
+	// In sat/vB, the UTXOs are merged only if the fee rate of GetSatoshiPerByte doesn't exceed it.
+	MaxFeeRate int64
+	// In sat/vB, the fee rate expected when the UTXOs are spent later, the savings are counted at it.
+	// defaults to DefaultLongTermFeeRate.
+	LongTermFeeRate int64
+	// The largest transaction Consolidate creates, defaults to 100000 vB, the most nodes relay.
+	MaxVSize int64
+	// defaults to netchain.MainNet.
+	Net netchain.Net
+	// defaults to addressinfo.FetchFromBlockcypher.
+	Fetch addressinfo.Fetch
+	// defaults to addressinfo.GetSatoshiPerByteFromBlockchain.
+	GetSatoshiPerByte addressinfo.GetSatoshiPerByte
+}
+
+// Consolidation describes the transactions merging the small UTXOs.
+type Consolidation struct {
+	// Each of them merges the UTXOs into one output.
+	Transactions []CreateResult
+	// Number of the merged UTXOs.
+	Merged int
+	// In satoshi, the fee of all the Transactions.
+	Fee int64
+	// In satoshi, how much cheaper spending the merged outputs at the LongTermFeeRate is than spending the small UTXOs,
+	// with the Fee deducted. Negative if the consolidation doesn't pay off.
+	Savings int64
+}
+
+// Consolidate merges the UTXOs of the private keys below the MaxUTXOValue into a few outputs while the fees are low,
+// so that they don't cost a lot to spend when the fees rise. It creates one transaction per MaxVSize of the inputs.
+// It fails with ErrFeeTooHigh if the current fee rate is above the MaxFeeRate.
+func Consolidate(params ConsolidateParams) (Consolidation, error) {
+	params, err := checkConsolidateParams(params)
+	if err != nil {
+		return Consolidation{}, err
+	}
+	satoshiPerByte, err := params.GetSatoshiPerByte(params.Net)
+	if err != nil {
+		return Consolidation{}, fmt.Errorf("couldn't fetch satoshiPerByte: %w", err)
+	}
+	feeRate := int64(satoshiPerByte)
+	if feeRate > params.MaxFeeRate {
+		return Consolidation{}, fmt.Errorf("%w, fee rate is %d sat/vB, max=%d", ErrFeeTooHigh, feeRate, params.MaxFeeRate)
+	}
+
+	sweepParams := SweepParams{
+		PrivateKeys: params.PrivateKeys,
+		AddressType: params.AddressType,
+		Destination: params.Destination,
+		FeeRate:     feeRate,
+		MaxVSize:    params.MaxVSize,
+		Net:         params.Net,
+		Fetch:       params.Fetch,
+	}
+	sweepParams, err = checkSweepParams(sweepParams)
+	if err != nil {
+		return Consolidation{}, err
+	}
+	fetched, err := fetchUTXOsToSweep(sweepParams)
+	if err != nil {
+		return Consolidation{}, err
+	}
+	var utxos []sweptUTXO
+	for _, u := range fetched {
+		if u.Balance < params.MaxUTXOValue {
+			utxos = append(utxos, u)
+		}
+	}
+	if len(utxos) < 2 {
+		return Consolidation{}, fmt.Errorf("found %d UTXOs below %d satoshi, nothing to consolidate", len(utxos), params.MaxUTXOValue)
+	}
+
+	results, err := sweepUTXOs(sweepParams, utxos)
+	if err != nil {
+		return Consolidation{}, err
+	}
+	destScript, err := addressToPkScript(params.Destination, params.Net)
+	if err != nil {
+		return Consolidation{}, err
+	}
+	c := Consolidation{Transactions: results, Merged: len(utxos)}
+	for _, u := range utxos {
+		c.Savings += feeOfWeight(params.LongTermFeeRate, u.weight)
+	}
+	merged := utxoWithKey{pkScript: destScript}
+	for _, privKey := range params.PrivateKeys {
+		// spending the merged output of an own key depends on whether the key is compressed
+		info, err := toPkInfo(privKey, params.AddressType, params.Net)
+		if err == nil && bytes.Equal(info.pkScript, destScript) {
+			merged.pkInfo = info
+			break
+		}
+	}
+	mergedWeight, _ := inputWeight(merged)
+	for _, result := range results {
+		c.Fee += result.Fee
+		c.Savings -= feeOfWeight(params.LongTermFeeRate, mergedWeight)
+	}
+	c.Savings -= c.Fee
+	return c, nil
+}
+
+func checkConsolidateParams(p ConsolidateParams) (ConsolidateParams, error) {
+	if len(p.PrivateKeys) == 0 {
+		return ConsolidateParams{}, fmt.Errorf("must specify PrivateKeys")
+	}
+	if p.MaxUTXOValue <= 0 {
+		return ConsolidateParams{}, fmt.Errorf("must specify MaxUTXOValue")
+	}
+	if p.MaxFeeRate <= 0 {
+		return ConsolidateParams{}, fmt.Errorf("must specify MaxFeeRate")
+	}
+	if p.LongTermFeeRate == 0 {
+		p.LongTermFeeRate = DefaultLongTermFeeRate
+	}
+	if p.LongTermFeeRate < 0 {
+		return ConsolidateParams{}, fmt.Errorf("LongTermFeeRate can't be negative")
+	}
+	if p.Net == "" {
+		p.Net = netchain.MainNet
+	}
+	if err := checkNet(p.Net); err != nil {
+		return ConsolidateParams{}, err
+	}
+	if p.Fetch == nil {
+		p.Fetch = addressinfo.FetchFromBlockcypher
+	}
+	if p.GetSatoshiPerByte == nil {
+		p.GetSatoshiPerByte = addressinfo.GetSatoshiPerByteFromBlockchain
+	}
+	if p.Destination == "" {
+		info, err := toPkInfo(p.PrivateKeys[0], p.AddressType, p.Net)
+		if err != nil {
+			return ConsolidateParams{}, err
+		}
+		p.Destination = info.address
+	}
+	return p, nil
+}
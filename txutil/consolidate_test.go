@@ -0,0 +1,79 @@
+package txutil
+
+import (
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/glossd/btc/addressinfo"
+	"github.com/glossd/btc/netchain"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// fetchSmallUTXOs gives the address 20 UTXOs of 2000 satoshi and one of 1e6.
+func fetchSmallUTXOs(address string, net netchain.Net) (addressinfo.Address, error) {
+	addr, err := addressinfo.FetchMock(address, net)
+	if err != nil {
+		return addressinfo.Address{}, err
+	}
+	large := addr.UTXOs[0]
+	for i := 0; i < 20; i++ {
+		u := large
+		u.TxID = chainhash.HashH([]byte(fmt.Sprint(address, i))).String()
+		u.Balance = 2000
+		addr.UTXOs = append(addr.UTXOs, u)
+		addr.Balance += u.Balance
+	}
+	return addr, nil
+}
+
+func TestConsolidate(t *testing.T) {
+	params := ConsolidateParams{
+		PrivateKeys:       []string{privateKey1, privateKey2},
+		MaxUTXOValue:      10000,
+		MaxFeeRate:        2,
+		LongTermFeeRate:   20,
+		Net:               netchain.TestNet,
+		Fetch:             fetchSmallUTXOs,
+		GetSatoshiPerByte: func(netchain.Net) (int, error) { return 1, nil },
+	}
+
+	t.Run("Merge", func(t *testing.T) {
+		c, err := Consolidate(params)
+		assert.Nil(t, err)
+		assert.EqualValues(t, 40, c.Merged)
+		assert.EqualValues(t, 1, len(c.Transactions))
+		tx := decodeTx(t, c.Transactions[0].RawTx)
+		assert.EqualValues(t, 40, len(tx.TxIn))
+		assert.EqualValues(t, 1, len(tx.TxOut))
+		assert.EqualValues(t, addressPkScript(t, destination1), tx.TxOut[0].PkScript)
+		assert.EqualValues(t, 40*2000-c.Fee, tx.TxOut[0].Value)
+		// one uncompressed legacy input instead of 20 uncompressed and 20 compressed ones at 20 sat/vB
+		spent := 20*feeOfWeight(20, p2pkhUncompressedInputWeight+1) + 20*feeOfWeight(20, p2pkhInputWeight+1)
+		assert.EqualValues(t, spent-feeOfWeight(20, p2pkhUncompressedInputWeight)-c.Fee, c.Savings)
+	})
+	t.Run("SeveralTransactions", func(t *testing.T) {
+		p := params
+		p.MaxVSize = 2000
+		c, err := Consolidate(p)
+		assert.Nil(t, err)
+		assert.EqualValues(t, 4, len(c.Transactions))
+		var inputs int
+		for _, result := range c.Transactions {
+			inputs += len(decodeTx(t, result.RawTx).TxIn)
+		}
+		assert.EqualValues(t, 40, inputs)
+	})
+	t.Run("FeeTooHigh", func(t *testing.T) {
+		p := params
+		p.GetSatoshiPerByte = func(netchain.Net) (int, error) { return 3, nil }
+		_, err := Consolidate(p)
+		assert.True(t, errors.Is(err, ErrFeeTooHigh))
+	})
+	t.Run("NothingToMerge", func(t *testing.T) {
+		p := params
+		p.Fetch = addressinfo.FetchMock
+		_, err := Consolidate(p)
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,184 @@
+package txutil
+
+import (
+	"fmt"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/glossd/btc/addressinfo"
+	"github.com/glossd/btc/netchain"
+)
+
+// The lowest fee rate in sat/vB nodes relay a transaction with, the default of Bitcoin Core.
+const minRelayFeeRate = 1
+
+type CPFPParams struct {
+	// Hash of the unconfirmed transaction to speed up.
+	ParentTxID string
+	// WIF-format keys of the parent's outputs which the child spends.
+	PrivateKeys []string
+	// Bitcoin address receiving the child's output, defaults to the address of the first spent output.
+	Destination string
+	// The fee rate in sat/vB of the parent and the child together.
+	FeeRate int64
+	// defaults to netchain.MainNet.
+	Net netchain.Net
+	// Checks the parent's outputs are unspent. defaults to addressinfo.FetchFromBlockcypher.
+	Fetch addressinfo.Fetch
+	// Fetches the parent and the transactions it spends. defaults to addressinfo.FetchRawTxFromBlockcypher.
+	FetchRawTx addressinfo.FetchRawTx
+}
+
+// CreateCPFP speeds up the parent transaction by spending its outputs with a child paying the higher fee.
+// The child pays for itself and for the part of the parent's size which the parent's fee doesn't cover,
+// so that the parent and the child together reach the fee rate.
+// It works for any transaction paying to the keys, e.g. incoming payments or transactions without RBF.
+func CreateCPFP(params CPFPParams) (string, error) {
+	params, err := checkCPFPParams(params)
+	if err != nil {
+		return "", err
+	}
+	parentHash, err := chainhash.NewHashFromStr(params.ParentTxID)
+	if err != nil {
+		return "", err
+	}
+	parent, err := fetchPrevTx(*wire.NewOutPoint(parentHash, 0), params.FetchRawTx, params.Net)
+	if err != nil {
+		return "", err
+	}
+	parentFee, err := fetchFee(parent, params.FetchRawTx, params.Net)
+	if err != nil {
+		return "", err
+	}
+	parentVSize := virtualSize(parent)
+	if parentFee >= params.FeeRate*parentVSize {
+		return "", fmt.Errorf("parent already pays %.1f sat/vB", float64(parentFee)/float64(parentVSize))
+	}
+
+	inputs, err := findUnspentOutputsOfKeys(parent, params)
+	if err != nil {
+		return "", err
+	}
+	var inputsValue int64
+	for _, in := range inputs {
+		inputsValue += in.Balance
+	}
+	destScript := inputs[0].pkInfo.pkScript
+	if params.Destination != "" {
+		destScript, err = addressToPkScript(params.Destination, params.Net)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// the child's size depends on its signatures, the fee is adjusted until it covers the signed child
+	var childFee int64
+	for attempt := 0; attempt < 10; attempt++ {
+		if isDust(inputsValue-childFee, destScript, DefaultDustRelayFee) {
+			return "", &InsufficientFundsError{Needed: childFee + DustLimit(destScript, DefaultDustRelayFee), Available: inputsValue}
+		}
+		child := wire.NewMsgTx(wire.TxVersion)
+		for _, in := range inputs {
+			err := addInputs(child, in.UTXOs, wire.MaxTxInSequenceNum)
+			if err != nil {
+				return "", err
+			}
+		}
+		child.AddTxOut(wire.NewTxOut(inputsValue-childFee, destScript))
+		err := signTx(child, inputs)
+		if err != nil {
+			return "", err
+		}
+
+		required := cpfpFee(params.FeeRate, parentFee, parentVSize, virtualSize(child))
+		if childFee >= required {
+			return hexEncodeTx(child)
+		}
+		childFee = required
+	}
+	return "", fmt.Errorf("couldn't fit the fee of the child")
+}
+
+func checkCPFPParams(p CPFPParams) (CPFPParams, error) {
+	if p.ParentTxID == "" {
+		return CPFPParams{}, fmt.Errorf("must specify ParentTxID")
+	}
+	if len(p.PrivateKeys) == 0 {
+		return CPFPParams{}, fmt.Errorf("must specify PrivateKeys")
+	}
+	if p.FeeRate <= 0 {
+		return CPFPParams{}, fmt.Errorf("must specify FeeRate")
+	}
+	if p.Net == "" {
+		p.Net = netchain.MainNet
+	}
+	if err := checkNet(p.Net); err != nil {
+		return CPFPParams{}, err
+	}
+	if p.Fetch == nil {
+		p.Fetch = addressinfo.FetchFromBlockcypher
+	}
+	if p.FetchRawTx == nil {
+		p.FetchRawTx = addressinfo.FetchRawTxFromBlockcypher
+	}
+	return p, nil
+}
+
+// cpfpFee is the fee of the child lifting the package of the parent and the child to the fee rate.
+func cpfpFee(feeRate, parentFee, parentVSize, childVSize int64) int64 {
+	fee := feeRate*(parentVSize+childVSize) - parentFee
+	if minFee := minRelayFeeRate * childVSize; fee < minFee {
+		fee = minFee
+	}
+	return fee
+}
+
+// fetchFee returns the fee of the transaction, fetching the outputs its inputs spend.
+func fetchFee(tx *wire.MsgTx, fetchRawTx addressinfo.FetchRawTx, net netchain.Net) (int64, error) {
+	var inputsValue int64
+	for _, in := range tx.TxIn {
+		prevTx, err := fetchPrevTx(in.PreviousOutPoint, fetchRawTx, net)
+		if err != nil {
+			return 0, err
+		}
+		inputsValue += prevTx.TxOut[in.PreviousOutPoint.Index].Value
+	}
+	return inputsValue - sumOutputs(tx.TxOut), nil
+}
+
+// findUnspentOutputsOfKeys returns the outputs of the parent paying to the keys which are still unspent.
+func findUnspentOutputsOfKeys(parent *wire.MsgTx, params CPFPParams) ([]address, error) {
+	keys, err := toPkInfosOfAllTypes(params.PrivateKeys, params.Net)
+	if err != nil {
+		return nil, err
+	}
+	parentHash := parent.TxHash()
+	unspentOfAddr := make(map[string]map[string]bool)
+	var result []address
+	for i, out := range parent.TxOut {
+		info, ok := findKeyOfScript(keys, out.PkScript)
+		if !ok {
+			continue
+		}
+		unspent, ok := unspentOfAddr[info.address]
+		if !ok {
+			addr, err := params.Fetch(info.address, params.Net)
+			if err != nil {
+				return nil, err
+			}
+			unspent = make(map[string]bool)
+			for _, u := range addr.UTXOs {
+				unspent[outPointKey(u)] = true
+			}
+			unspentOfAddr[info.address] = unspent
+		}
+		outPoint := wire.NewOutPoint(&parentHash, uint32(i))
+		candidate := toSingleUTXOAddress(*outPoint, out, info)
+		if unspent[outPointKey(candidate.UTXOs[0])] {
+			result = append(result, candidate)
+		}
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("transaction %s doesn't have unspent outputs of the private keys", parentHash)
+	}
+	return result, nil
+}
@@ -0,0 +1,105 @@
+package txutil
+
+import (
+	"encoding/hex"
+	"github.com/glossd/btc/addressinfo"
+	"github.com/glossd/btc/netchain"
+	"github.com/glossd/btc/wallet"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCreateCPFP(t *testing.T) {
+	segwitAddr, err := wallet.AddressFromPrivateKeyWithType(privateKey2, netchain.TestNet, wallet.P2WPKH)
+	assert.Nil(t, err)
+	fetch, fetchRawTx := fetchMockWithPrevTxs(t)
+	var parentFee int64 = 200
+	rawParent, err := Create(CreateParams{
+		PrivateKey:  privateKey2,
+		AddressType: wallet.P2WPKH,
+		Destination: destination1,
+		Amount:      5e5,
+		MinerFee:    parentFee,
+		Fetch:       fetch,
+		Net:         netchain.TestNet,
+	})
+	assert.Nil(t, err)
+	parent := decodeTx(t, rawParent)
+	parentID := parent.TxHash().String()
+	change := outputTo(t, parent, addressPkScript(t, segwitAddr))
+	changeIdx := 0
+	if parent.TxOut[1] == change {
+		changeIdx = 1
+	}
+
+	cpfpParams := CPFPParams{
+		ParentTxID:  parentID,
+		PrivateKeys: []string{privateKey2},
+		FeeRate:     20,
+		Net:         netchain.TestNet,
+		// the change of the parent is the only unspent output of the address
+		Fetch: func(address string, net netchain.Net) (addressinfo.Address, error) {
+			return addressinfo.Address{Balance: change.Value, UTXOs: []addressinfo.UTXO{{
+				TxID:     parentID,
+				Pbscript: hex.EncodeToString(change.PkScript),
+				Balance:  change.Value,
+				TxOutIdx: changeIdx,
+			}}}, nil
+		},
+		FetchRawTx: func(txID string, net netchain.Net) (string, error) {
+			if txID == parentID {
+				return rawParent, nil
+			}
+			return fetchRawTx(txID, net)
+		},
+	}
+
+	t.Run("FromChange", func(t *testing.T) {
+		rawChild, err := CreateCPFP(cpfpParams)
+		assert.Nil(t, err)
+
+		child := decodeTx(t, rawChild)
+		assert.EqualValues(t, 1, len(child.TxIn))
+		assert.EqualValues(t, parentID, child.TxIn[0].PreviousOutPoint.Hash.String())
+		assert.EqualValues(t, changeIdx, child.TxIn[0].PreviousOutPoint.Index)
+		assert.EqualValues(t, 1, len(child.TxOut))
+		assert.EqualValues(t, addressPkScript(t, segwitAddr), child.TxOut[0].PkScript)
+
+		childFee := change.Value - child.TxOut[0].Value
+		packageVSize := virtualSize(parent) + virtualSize(child)
+		assert.GreaterOrEqual(t, parentFee+childFee, 20*packageVSize)
+		assert.Less(t, parentFee+childFee, 21*packageVSize)
+		verifyInput(t, child, 0, change.PkScript, change.Value)
+	})
+	t.Run("ToDestination", func(t *testing.T) {
+		params := cpfpParams
+		params.Destination = destination3
+		rawChild, err := CreateCPFP(params)
+		assert.Nil(t, err)
+		child := decodeTx(t, rawChild)
+		assert.EqualValues(t, addressPkScript(t, destination3), child.TxOut[0].PkScript)
+	})
+	t.Run("Validation", func(t *testing.T) {
+		params := cpfpParams
+		params.FeeRate = 1
+		_, err := CreateCPFP(params)
+		assert.NotNil(t, err, "parent already pays the fee rate")
+
+		params = cpfpParams
+		params.PrivateKeys = []string{privateKey3}
+		_, err = CreateCPFP(params)
+		assert.NotNil(t, err, "no outputs of the keys")
+
+		params = cpfpParams
+		params.Fetch = func(address string, net netchain.Net) (addressinfo.Address, error) {
+			return addressinfo.Address{}, nil
+		}
+		_, err = CreateCPFP(params)
+		assert.NotNil(t, err, "the output is already spent")
+
+		params = cpfpParams
+		params.FeeRate = 0
+		_, err = CreateCPFP(params)
+		assert.NotNil(t, err, "no fee rate")
+	})
+}
@@ -2,15 +2,18 @@ package txutil
 
 import (
 	"bytes"
+	"crypto/rand"
 	"encoding/hex"
 	"fmt"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
-	"github.com/btcsuite/btcutil"
 	"github.com/glossd/btc/addressinfo"
 	"github.com/glossd/btc/netchain"
 	"github.com/glossd/btc/wallet"
+	"math/big"
 	"sort"
 	"strconv"
 )
@@ -19,35 +22,104 @@ const DefaultMinerFee = 5000
 
 const maxMinerFee = 50000
 
-// https://support.blockchain.com/hc/en-us/articles/210354003-What-is-the-minimum-amount-I-can-send-
-const minSatoshiToSend = 546
+// The largest size of the OP_RETURN scripts of a transaction the nodes relay, the default of Bitcoin Core.
+// It leaves 80 bytes to the data of a single output.
+const maxDataCarrierSize = 83
+
+// The nodes relay the transactions with one OP_RETURN output at most.
+const maxDataOutputs = 1
 
 type CreateParams struct {
 	// WIF-format. Will be omitted if PrivateKeys are specified.
 	PrivateKey string
 	// Iteratively includes each key in transaction until the full amount can be transferred.
-	// If the last used private key had some satoshi left, that remainder will be sent to that last private key.
+	// If the last used private key had some satoshi left, that remainder will be sent to the ChangeAddress.
 	PrivateKeys []string
+	// Sign with the private keys kept outside of CreateParams, e.g. in KMS or HSM. Will be omitted if PrivateKey is specified.
+	// NewWIFSigner signs with the WIF-format private key.
+	Signers []Signer
+	// Hex-encoded public keys, used by CreatePSBT instead of the private keys. Will be omitted if Signers are specified.
+	PublicKeys []string
+	// Addresses to spend from, used by CreatePSBT instead of the keys. Will be omitted if PublicKeys are specified.
+	// Signers of P2SH-P2WPKH inputs provide the redeem script in SignPSBT.
+	Addresses []string
+	// Type of the address the keys hold their bitcoins on, defaults to wallet.P2PKH.
+	// e.g. wallet.P2WPKH for keys of bech32 wallets.
+	AddressType wallet.AddressType
+	// M-of-N multisig wallet to spend from, its AddressType must be wallet.P2SH, wallet.P2WSH or wallet.P2SHP2WSH.
+	// Create signs with the PrivateKeys or Signers of the required number of co-signers,
+	// CreatePSBT lets each co-signer sign with SignPSBT on their own.
+	Multisig *Multisig
+	// UTXOs to spend, Create spends exactly them without calling Fetch and without the CoinSelector,
+	// e.g. to create the transaction offline. Each of them must be locked to the address of one of the keys.
+	UTXOs []addressinfo.UTXO
+	// Only the UTXOs with at least that many confirmations are spent, defaults to 0 spending the unconfirmed ones too.
+	// The coinbase UTXOs are never spent before addressinfo.CoinbaseMaturity confirmations.
+	MinConfirmations int
 	// Bitcoin address of the receiver. Amount or SendAll must be set. Will be omitted if Destinations are specified.
 	Destination string
 	// Parameter for Destination. Measured in satoshi. Will be omitted if SendAll is true.
 	Amount       int64
 	Destinations []Destination
-	// If true, all satoshi will be sent. Only works if you specified only one destination.
+	// If true, all satoshi will be sent. Several Destinations split them by their Share.
 	SendAll bool
-	// In satoshi, defaults to DefaultMinerFee. Will be omitted if AutoMinerFee is true.
+	// Splits the fee among the SubtractFee destinations in proportion to their amounts instead of evenly.
+	SubtractFeeByAmount bool
+	// Data embedded into the transaction with zero-value OP_RETURN outputs, e.g. an order reference or a document hash.
+	// Only one output is relayed by the nodes, its script can't exceed 83 bytes, which is 80 bytes of data.
+	DataOutputs [][]byte
+	// Bitcoin address receiving the change, defaults to the address of the last private key.
+	// A fresh address for every transaction keeps the addresses of the keys from being linked together.
+	ChangeAddress string
+	// In satoshi, defaults to DefaultMinerFee. Will be omitted if FeeRate is set or AutoMinerFee is true.
 	MinerFee int64
-	// Automatically calculates MinerFee. Will call an API addressinfo.GetSatoshiPerByte.
+	// In sat/vB, calculates MinerFee from the virtual size the transaction has once signed.
+	FeeRate int64
+	// Automatically calculates MinerFee. Will call an API addressinfo.GetSatoshiPerByte for the FeeRate.
 	AutoMinerFee bool
+	// Chooses the UTXOs of the keys to spend, e.g. BranchAndBound{} or LargestFirst{}. Not used with SendAll.
+	// By default the keys are spent one after another, the UTXOs of the last key from the smallest.
+	CoinSelector CoinSelector
+	// In sat/vB, the fee rate the UTXOs are expected to be spent at later, which the CoinSelector weighs the fee against.
+	// defaults to DefaultLongTermFeeRate.
+	LongTermFeeRate int64
+	// In sat/vB, defaults to DefaultDustRelayFee. The outputs below the DustLimit of their script aren't relayed,
+	// the change below it goes to the miners.
+	DustRelayFee int64
+	// Signals BIP125 replace-by-fee, the transaction can be replaced with a higher fee through BumpFee.
+	RBF bool
+	// The transaction can't be mined before the block height, must be below 500000000.
+	LockTimeHeight uint32
+	// The transaction can't be mined before the unix time, must be after 500000000 i.e. 1985-11-05.
+	LockTimeUnix int64
+	// Sets the sequence of the input spending the UTXO, e.g. RelativeLockBlocks for CSV-locked outputs.
+	// Overrides the sequences of RBF and of the locktime.
+	Sequence func(utxo addressinfo.UTXO) uint32
 	// defaults to netchain.MainNet.
 	Net netchain.Net
 	// defaults to addressinfo.FetchFromBlockcypher.
 	Fetch addressinfo.Fetch
 	// defaults to addressinfo.GetSatoshiPerByteFromBlockchain.
 	GetSatoshiPerByte addressinfo.GetSatoshiPerByte
+	// Used by CreatePSBT for legacy inputs, which require the full previous transaction.
+	// defaults to addressinfo.FetchRawTxFromBlockcypher.
+	FetchRawTx addressinfo.FetchRawTx
+
+	pkInfos      []privateKeyInfo
+	destInfos    []destinationInfo
+	changeScript []byte
+	// the change the fee of the FeeRate absorbed
+	dustChange int64
+}
 
-	pkInfos   []privateKeyInfo
-	destInfos []destinationInfo
+// CreateResult describes the transaction built by Create.
+type CreateResult struct {
+	// Hex-encoded signed transaction.
+	RawTx string
+	// In satoshi, what the miners get.
+	Fee int64
+	// In satoshi, the change below the dust limit, which went to the miners as part of the Fee instead of its own output.
+	DustChange int64
 }
 
 type Destination struct {
@@ -55,12 +127,39 @@ type Destination struct {
 	Address string
 	// Measured in satoshi.
 	Amount int64
+	// The receiver pays the fee out of the Amount instead of the sender.
+	// The fee is split evenly among such destinations. Not used with SendAll.
+	SubtractFee bool
+	// Weight of the destination in the split of SendAll, e.g. 70, 20 and 10. The Amount is omitted.
+	// Required if SendAll has several destinations.
+	Share int64
 }
 
 func (cp CreateParams) fullCost() int64 {
+	if cp.subtractsFee() {
+		return cp.fullAmount()
+	}
 	return cp.fullAmount() + cp.MinerFee
 }
 
+func (cp CreateParams) sequence() uint32 {
+	if cp.RBF {
+		return rbfSequence
+	}
+	if cp.lockTime() != 0 {
+		// the locktime is enforced only if one of the inputs isn't final
+		return wire.MaxTxInSequenceNum - 1
+	}
+	return wire.MaxTxInSequenceNum
+}
+
+func (cp CreateParams) lockTime() uint32 {
+	if cp.LockTimeHeight != 0 {
+		return cp.LockTimeHeight
+	}
+	return uint32(cp.LockTimeUnix)
+}
+
 func (cp CreateParams) fullAmount() int64 {
 	var result int64
 	for _, info := range cp.destInfos {
@@ -70,123 +169,380 @@ func (cp CreateParams) fullAmount() int64 {
 }
 
 func Create(params CreateParams) (string, error) {
-	params, err := checkCreateParams(params)
+	result, err := CreateWithResult(params)
 	if err != nil {
 		return "", err
 	}
+	return result.RawTx, nil
+}
+
+// CreateWithResult works as Create and also reports the fee and the change which went to the miners.
+func CreateWithResult(params CreateParams) (CreateResult, error) {
+	params, err := checkCreateParams(params)
+	if err != nil {
+		return CreateResult{}, err
+	}
+	for _, info := range params.pkInfos {
+		if info.multisigScript() != nil {
+			if err := checkCosigners(info); err != nil {
+				return CreateResult{}, err
+			}
+		} else if info.signer == nil {
+			return CreateResult{}, fmt.Errorf("must specify either PrivateKey, PrivateKeys or Signers, use CreatePSBT to spend from public keys or addresses")
+		}
+	}
 
-	addrs, err := getAddressesToWithdrawFrom(params)
+	params, addrs, err := selectAddresses(params)
 	if err != nil {
-		return "", err
+		return CreateResult{}, err
 	}
 
-	txHex, err := buildTx(params, addrs)
+	tx, err := buildTx(params, addrs)
 	if err != nil {
-		return "", err
+		return CreateResult{}, err
+	}
+	rawTx, err := hexEncodeTx(tx)
+	if err != nil {
+		return CreateResult{}, err
+	}
+	utxosOfIns, err := utxosOfInputs(tx, addrs)
+	if err != nil {
+		return CreateResult{}, err
+	}
+	var inputsValue int64
+	for _, u := range utxosOfIns {
+		inputsValue += u.Balance
 	}
+	fee := inputsValue - sumOutputs(tx.TxOut)
+	// with the fixed MinerFee the dust change is left over by addTxOutputs
+	return CreateResult{RawTx: rawTx, Fee: fee, DustChange: fee - params.MinerFee + params.dustChange}, nil
+}
 
+// selectAddresses returns the addresses to withdraw from, setting the MinerFee of the FeeRate.
+func selectAddresses(params CreateParams) (CreateParams, []address, error) {
 	if params.AutoMinerFee {
-		// calculating miner fee. In case %2==1, added +1
-		bytesNum := (len(txHex) + 1) / 2
-		satoshiPerByte, err := params.GetSatoshiPerByte(params.Net)
+		var err error
+		params, err = fetchFeeRate(params)
 		if err != nil {
-			return "", fmt.Errorf("couldn't fetch satoshiPerByte: %s", err)
+			return CreateParams{}, nil, err
 		}
-		params.MinerFee = int64(bytesNum * satoshiPerByte)
-		if params.MinerFee > maxMinerFee {
+	}
+	var addrs []address
+	var err error
+	switch {
+	case params.CoinSelector != nil && !params.SendAll:
+		params, addrs, err = selectCoinsForTx(params)
+	case params.FeeRate == 0:
+		addrs, err = getAddressesToWithdrawFrom(params)
+	default:
+		params, addrs, err = fitMinerFee(params)
+		if err == nil && params.AutoMinerFee && params.MinerFee > maxMinerFee {
 			// preventing any possible losses
-			return "", fmt.Errorf("the maximum auto miner fee is reached, max=%d, got=%d", maxMinerFee, params.MinerFee)
+			err = &FeeTooHighError{Max: maxMinerFee, Got: params.MinerFee}
 		}
-		return buildTx(params, addrs)
-	} else {
-		return txHex, nil
 	}
+	if err != nil {
+		return CreateParams{}, nil, err
+	}
+	if err := checkFeeShares(params); err != nil {
+		return CreateParams{}, nil, err
+	}
+	if err := checkSendAllAmounts(params, addrs); err != nil {
+		return CreateParams{}, nil, err
+	}
+	return params, addrs, nil
+}
+
+func fetchFeeRate(params CreateParams) (CreateParams, error) {
+	satoshiPerByte, err := params.GetSatoshiPerByte(params.Net)
+	if err != nil {
+		return CreateParams{}, fmt.Errorf("couldn't fetch satoshiPerByte: %w", err)
+	}
+	params.FeeRate = int64(satoshiPerByte)
+	return params, nil
+}
+
+// fitMinerFee sets the MinerFee to pay the FeeRate for the estimated size of the transaction.
+// More inputs raise the fee, which may require more inputs, so the inputs are chosen again until the fee covers them.
+func fitMinerFee(params CreateParams) (CreateParams, []address, error) {
+	params.Fetch = cacheFetch(params.Fetch)
+	params.MinerFee = 0
+	for attempt := 0; attempt < 10; attempt++ {
+		addrs, err := getAddressesToWithdrawFrom(params)
+		if err != nil {
+			return CreateParams{}, nil, err
+		}
+		tx, err := buildUnsignedTx(params, addrs)
+		if err != nil {
+			return CreateParams{}, nil, err
+		}
+		vsize, err := estimateVSize(tx, addrs)
+		if err != nil {
+			return CreateParams{}, nil, err
+		}
+		required := params.FeeRate * vsize
+
+		// the change doesn't depend on the fee the destinations pay
+		hasChange := !params.SendAll && !params.subtractsFee() && len(tx.TxOut) > len(params.destInfos)
+		if hasChange {
+			change := tx.TxOut[len(tx.TxOut)-1]
+			if isDust(change.Value+params.MinerFee-required, change.PkScript, params.DustRelayFee) {
+				// the change isn't worth its own output, without it the transaction is smaller and the change goes to the miners
+				tx.TxOut = tx.TxOut[:len(tx.TxOut)-1]
+				vsize, err := estimateVSize(tx, addrs)
+				if err != nil {
+					return CreateParams{}, nil, err
+				}
+				if params.MinerFee+change.Value >= params.FeeRate*vsize {
+					params.MinerFee += change.Value
+					params.dustChange = params.MinerFee - params.FeeRate*vsize
+					return params, addrs, nil
+				}
+			}
+		}
+		if params.MinerFee >= required {
+			return params, addrs, nil
+		}
+		params.MinerFee = required
+	}
+	return CreateParams{}, nil, fmt.Errorf("couldn't fit the miner fee of %d sat/vB", params.FeeRate)
+}
+
+// cacheFetch remembers the fetched addresses, so that choosing the inputs again doesn't call the API.
+func cacheFetch(fetch addressinfo.Fetch) addressinfo.Fetch {
+	fetched := make(map[string]addressinfo.Address)
+	return func(addr string, net netchain.Net) (addressinfo.Address, error) {
+		if a, ok := fetched[addr]; ok {
+			return a, nil
+		}
+		a, err := fetch(addr, net)
+		if err != nil {
+			return addressinfo.Address{}, err
+		}
+		fetched[addr] = a
+		return a, nil
+	}
+}
+
+func buildTx(params CreateParams, addrs []address) (*wire.MsgTx, error) {
+	tx, err := buildUnsignedTx(params, addrs)
+	if err != nil {
+		return nil, err
+	}
+
+	err = shuffleOutputs(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = signTx(tx, addrs)
+	if err != nil {
+		return nil, err
+	}
+
+	return tx, nil
+}
+
+// shuffleOutputs randomizes the order of the outputs, so that the change can't be told by its position.
+func shuffleOutputs(tx *wire.MsgTx) error {
+	for i := len(tx.TxOut) - 1; i > 0; i-- {
+		j, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		if err != nil {
+			return err
+		}
+		tx.TxOut[i], tx.TxOut[j.Int64()] = tx.TxOut[j.Int64()], tx.TxOut[i]
+	}
+	return nil
 }
 
-func buildTx(params CreateParams, addrs []address) (string, error) {
+func buildUnsignedTx(params CreateParams, addrs []address) (*wire.MsgTx, error) {
 	tx := wire.NewMsgTx(wire.TxVersion)
 
 	satoshiRemainder, err := addUTXOsToTxInputs(tx, addrs, params)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	addTxOutputs(tx, params, satoshiRemainder, addrs)
 
-	err = signTx(tx, addrs)
+	err = setLocks(tx, params, addrs)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-
-	return hexEncodeTx(tx)
+	return tx, nil
 }
 
 func checkCreateParams(p CreateParams) (CreateParams, error) {
 	if p.MinerFee == 0 {
 		p.MinerFee = DefaultMinerFee
 	}
+	if p.MinerFee < 0 {
+		return CreateParams{}, fmt.Errorf("MinerFee can't be negative")
+	}
+	if p.FeeRate < 0 {
+		return CreateParams{}, fmt.Errorf("FeeRate can't be negative")
+	}
+	if err := checkLockTime(p); err != nil {
+		return CreateParams{}, err
+	}
+	if p.LongTermFeeRate == 0 {
+		p.LongTermFeeRate = DefaultLongTermFeeRate
+	}
+	if p.DustRelayFee == 0 {
+		p.DustRelayFee = DefaultDustRelayFee
+	}
 	if p.Net == "" {
 		p.Net = netchain.MainNet
 	}
+	if err := checkNet(p.Net); err != nil {
+		return CreateParams{}, err
+	}
 	if p.Fetch == nil {
 		p.Fetch = addressinfo.FetchFromBlockcypher
 	}
 	if p.GetSatoshiPerByte == nil {
 		p.GetSatoshiPerByte = addressinfo.GetSatoshiPerByteFromBlockchain
 	}
+	if p.FetchRawTx == nil {
+		p.FetchRawTx = addressinfo.FetchRawTxFromBlockcypher
+	}
 
 	if len(p.Destinations) == 0 {
 		if p.Destination == "" {
 			return CreateParams{}, fmt.Errorf("destination must be specified")
 		}
-		if p.Amount < minSatoshiToSend && !p.SendAll {
-			return CreateParams{}, fmt.Errorf("amount of satoshi can't be less than %d", minSatoshiToSend)
-		}
 		payAddress, err := addressToPkScript(p.Destination, p.Net)
 		if err != nil {
 			return CreateParams{}, err
 		}
+		if limit := DustLimit(payAddress, p.DustRelayFee); p.Amount < limit && !p.SendAll {
+			return CreateParams{}, fmt.Errorf("%w, amount of satoshi can't be less than %d", ErrDustOutput, limit)
+		}
 		p.destInfos = []destinationInfo{{
 			Destination: Destination{Address: p.Destination, Amount: p.Amount},
 			pkScript:    payAddress,
 		}}
 	} else {
-		if len(p.Destinations) > 1 && p.SendAll {
-			return CreateParams{}, fmt.Errorf("SendAll works with only one destination")
-		}
-		var fullAmount int64
 		var dInfos []destinationInfo
 		for _, d := range p.Destinations {
-			fullAmount += d.Amount
+			if d.Share < 0 || len(p.Destinations) > 1 && p.SendAll && d.Share == 0 {
+				return CreateParams{}, fmt.Errorf("SendAll to several destinations requires the positive Share of each")
+			}
 			info, err := toDestInfo(d, p.Net)
 			if err != nil {
 				return CreateParams{}, err
 			}
+			if limit := DustLimit(info.pkScript, p.DustRelayFee); d.Amount < limit && !p.SendAll {
+				return CreateParams{}, fmt.Errorf("%w, amount of satoshi to %s can't be less than %d", ErrDustOutput, d.Address, limit)
+			}
 			dInfos = append(dInfos, info)
 		}
-		sendAllToOneDest := len(dInfos) == 1 && p.SendAll
-		if fullAmount < minSatoshiToSend && !sendAllToOneDest {
-			return CreateParams{}, fmt.Errorf("full amount of satoshi can't be less than %d", minSatoshiToSend)
-		}
 		p.destInfos = dInfos
 	}
 
-	if len(p.PrivateKeys) > 0 {
+	if len(p.DataOutputs) > maxDataOutputs {
+		return CreateParams{}, fmt.Errorf("%w, %d OP_RETURN outputs, max=%d", ErrDataTooLarge, len(p.DataOutputs), maxDataOutputs)
+	}
+	var dataCarrierSize int
+	for _, data := range p.DataOutputs {
+		script, err := txscript.NullDataScript(data)
+		if err != nil {
+			return CreateParams{}, fmt.Errorf("%w: %s", ErrDataTooLarge, err)
+		}
+		dataCarrierSize += len(script)
+		if dataCarrierSize > maxDataCarrierSize {
+			return CreateParams{}, fmt.Errorf("%w, OP_RETURN scripts take %d bytes, max=%d", ErrDataTooLarge, dataCarrierSize, maxDataCarrierSize)
+		}
+		// the data doesn't get a Share of SendAll
+		p.destInfos = append(p.destInfos, destinationInfo{pkScript: script})
+	}
+
+	if p.Multisig != nil {
+		privKeys := p.PrivateKeys
+		if len(privKeys) == 0 && p.PrivateKey != "" {
+			privKeys = []string{p.PrivateKey}
+		}
+		signers := append([]Signer{}, p.Signers...)
+		for _, key := range privKeys {
+			signer, err := NewWIFSigner(key)
+			if err != nil {
+				return CreateParams{}, fmt.Errorf("one of the private keys is malformed: %w", err)
+			}
+			signers = append(signers, signer)
+		}
+		pkInfo, err := toMultisigInfo(*p.Multisig, signers, p.AddressType, p.Net)
+		if err != nil {
+			return CreateParams{}, err
+		}
+		p.pkInfos = []privateKeyInfo{pkInfo}
+	} else if len(p.PrivateKeys) > 0 {
 		for _, key := range p.PrivateKeys {
-			pkInfo, err := toPkInfo(key, p.Net)
+			pkInfo, err := toPkInfo(key, p.AddressType, p.Net)
 			if err != nil {
-				return CreateParams{}, fmt.Errorf("one of the private keys is malformed: %s", err)
+				return CreateParams{}, fmt.Errorf("one of the private keys is malformed: %w", err)
 			}
 			p.pkInfos = append(p.pkInfos, pkInfo)
 		}
 	} else if p.PrivateKey != "" {
-		pkInfo, err := toPkInfo(p.PrivateKey, p.Net)
+		pkInfo, err := toPkInfo(p.PrivateKey, p.AddressType, p.Net)
 		if err != nil {
 			return CreateParams{}, err
 		}
 		p.pkInfos = []privateKeyInfo{pkInfo}
+	} else if len(p.Signers) > 0 {
+		for _, signer := range p.Signers {
+			pkInfo, err := toSignerInfo(signer, p.AddressType, p.Net)
+			if err != nil {
+				return CreateParams{}, err
+			}
+			p.pkInfos = append(p.pkInfos, pkInfo)
+		}
+	} else if len(p.PublicKeys) > 0 {
+		for _, key := range p.PublicKeys {
+			pkInfo, err := toPubKeyInfo(key, p.AddressType, p.Net)
+			if err != nil {
+				return CreateParams{}, fmt.Errorf("one of the public keys is malformed: %w", err)
+			}
+			p.pkInfos = append(p.pkInfos, pkInfo)
+		}
+	} else if len(p.Addresses) > 0 {
+		for _, addr := range p.Addresses {
+			pkScript, err := addressToPkScript(addr, p.Net)
+			if err != nil {
+				return CreateParams{}, fmt.Errorf("one of the addresses is malformed: %w", err)
+			}
+			p.pkInfos = append(p.pkInfos, privateKeyInfo{address: addr, pkScript: pkScript})
+		}
+	} else {
+		return CreateParams{}, fmt.Errorf("must specify either PrivateKey, PrivateKeys, Signers, PublicKeys or Addresses")
+	}
+
+	p.changeScript = p.pkInfos[len(p.pkInfos)-1].pkScript
+	if p.ChangeAddress != "" {
+		changeScript, err := addressToPkScript(p.ChangeAddress, p.Net)
+		if err != nil {
+			return CreateParams{}, err
+		}
+		p.changeScript = changeScript
+	}
+
+	if p.MinConfirmations < 0 {
+		return CreateParams{}, fmt.Errorf("MinConfirmations can't be negative")
+	}
+	if len(p.UTXOs) > 0 {
+		for _, u := range p.UTXOs {
+			if !isSpendable(u, p.MinConfirmations) {
+				return CreateParams{}, fmt.Errorf("UTXO %s has %d confirmations, needs %d", outPointKey(u), u.Confirmations, requiredConfirmations(u, p.MinConfirmations))
+			}
+		}
+		fetch, err := fetchOfUTXOs(p.UTXOs, p.pkInfos)
+		if err != nil {
+			return CreateParams{}, err
+		}
+		p.Fetch = fetch
+		p.CoinSelector = spendAll{}
 	} else {
-		return CreateParams{}, fmt.Errorf("must specify either PrivateKey or PrivateKeys")
+		p.Fetch = fetchSpendable(p.Fetch, p.MinConfirmations)
 	}
 
 	return p, nil
@@ -194,7 +550,7 @@ func checkCreateParams(p CreateParams) (CreateParams, error) {
 
 type address struct {
 	addressinfo.Address
-	privateKey string
+	pkInfo privateKeyInfo
 }
 
 func getAddressesToWithdrawFrom(params CreateParams) ([]address, error) {
@@ -205,7 +561,7 @@ func getAddressesToWithdrawFrom(params CreateParams) ([]address, error) {
 		if err != nil {
 			return nil, err
 		}
-		addrsToWithdrawFrom = append(addrsToWithdrawFrom, address{Address: addr, privateKey: pkInfo.key})
+		addrsToWithdrawFrom = append(addrsToWithdrawFrom, address{Address: addr, pkInfo: pkInfo})
 		satoshiSum += addr.Balance
 		if !params.SendAll && satoshiSum >= params.fullCost() {
 			return addrsToWithdrawFrom, nil
@@ -214,7 +570,7 @@ func getAddressesToWithdrawFrom(params CreateParams) ([]address, error) {
 	if params.SendAll {
 		return addrsToWithdrawFrom, nil
 	} else {
-		return nil, fmt.Errorf("not enough satoshi to send, amount+fee=%d, balance=%d", params.fullCost(), satoshiSum)
+		return nil, &InsufficientFundsError{Needed: params.fullCost(), Available: satoshiSum}
 	}
 }
 
@@ -222,13 +578,21 @@ func addUTXOsToTxInputs(tx *wire.MsgTx, addrs []address, params CreateParams) (s
 	amountLeftToRedeem := params.fullCost()
 	for i, addr := range addrs {
 		isLastAddr := i == len(addrs)-1
+		if isLastAddr && params.CoinSelector != nil && !params.SendAll {
+			// the CoinSelector has already chosen the UTXOs
+			err := addInputs(tx, addr.UTXOs, params.sequence())
+			return calcBalanceOfAddresses(addrs) - params.fullCost(), err
+		}
 		if isLastAddr && !params.SendAll {
-			lastUTXOs, theirBalance := chooseUTXOs(addr.UTXOs, amountLeftToRedeem)
+			lastUTXOs, theirBalance, err := chooseUTXOs(addr.UTXOs, amountLeftToRedeem)
+			if err != nil {
+				return 0, err
+			}
 			satoshiRemainder = theirBalance - amountLeftToRedeem
-			err := addInputs(tx, lastUTXOs)
+			err = addInputs(tx, lastUTXOs, params.sequence())
 			return satoshiRemainder, err
 		}
-		err := addInputs(tx, addr.UTXOs)
+		err := addInputs(tx, addr.UTXOs, params.sequence())
 		if err != nil {
 			return 0, err
 		}
@@ -237,7 +601,7 @@ func addUTXOsToTxInputs(tx *wire.MsgTx, addrs []address, params CreateParams) (s
 	return satoshiRemainder, nil
 }
 
-func addInputs(tx *wire.MsgTx, utxos []addressinfo.UTXO) error {
+func addInputs(tx *wire.MsgTx, utxos []addressinfo.UTXO, sequence uint32) error {
 	for _, utxo := range utxos {
 		utxoHash, err := chainhash.NewHashFromStr(utxo.TxID)
 		if err != nil {
@@ -245,6 +609,7 @@ func addInputs(tx *wire.MsgTx, utxos []addressinfo.UTXO) error {
 		}
 		outPoint := wire.NewOutPoint(utxoHash, uint32(utxo.TxOutIdx))
 		txIn := wire.NewTxIn(outPoint, nil, nil)
+		txIn.Sequence = sequence
 		tx.AddTxIn(txIn)
 	}
 	return nil
@@ -252,26 +617,67 @@ func addInputs(tx *wire.MsgTx, utxos []addressinfo.UTXO) error {
 
 func addTxOutputs(tx *wire.MsgTx, params CreateParams, satoshiRemainder int64, addrs []address) {
 	if params.SendAll {
-		fullBalance := calcBalanceOfAddresses(addrs)
-		tx.AddTxOut(wire.NewTxOut(fullBalance-params.MinerFee, params.destInfos[0].pkScript))
+		amounts := params.sendAllAmounts(calcBalanceOfAddresses(addrs) - params.MinerFee)
+		for i, info := range params.destInfos {
+			tx.AddTxOut(wire.NewTxOut(amounts[i], info.pkScript))
+		}
 	} else {
-		for _, info := range params.destInfos {
-			tx.AddTxOut(wire.NewTxOut(info.Amount, info.pkScript))
+		shares := params.feeShares()
+		for i, info := range params.destInfos {
+			tx.AddTxOut(wire.NewTxOut(info.Amount-shares[i], info.pkScript))
 		}
-		if satoshiRemainder > 0 {
-			tx.AddTxOut(wire.NewTxOut(satoshiRemainder, params.pkInfos[len(params.pkInfos)-1].pkScript))
+		changeScript := params.changeScript
+		// the change below the dust limit is left to the miners
+		if satoshiRemainder > 0 && !isDust(satoshiRemainder, changeScript, params.DustRelayFee) {
+			tx.AddTxOut(wire.NewTxOut(satoshiRemainder, changeScript))
 		}
 	}
 }
 
+// privateKeyInfo describes where the bitcoins of a key are. Keys of CreatePSBT can be watch-only,
+// then signer is nil, and pubKey is nil if only the address is known.
 type privateKeyInfo struct {
-	key      string
+	signer   Signer
+	pubKey   *btcec.PublicKey
 	address  string
 	pkScript []byte
+	// the P2PKH address hashes the uncompressed public key
+	uncompressed bool
+	// only set for P2SH addresses
+	redeemScript []byte
+	// only set for P2WSH and P2SH-P2WSH multisig addresses
+	witnessScript []byte
+	// signers of the co-signers of the multisig address, sorted as their public keys in the script
+	cosigners []Signer
+}
+
+func toPkInfo(privKey string, addrType wallet.AddressType, net netchain.Net) (privateKeyInfo, error) {
+	signer, err := NewWIFSigner(privKey)
+	if err != nil {
+		return privateKeyInfo{}, err
+	}
+	return toSignerInfo(signer, addrType, net)
 }
 
-func toPkInfo(privKey string, net netchain.Net) (privateKeyInfo, error) {
-	addr, err := wallet.AddressFromPrivateKey(privKey, net)
+func toSignerInfo(signer Signer, addrType wallet.AddressType, net netchain.Net) (privateKeyInfo, error) {
+	pubKey := signer.PublicKey().SerializeCompressed()
+	if isUncompressed(signer) {
+		pubKey = signer.PublicKey().SerializeUncompressed()
+	}
+	info, err := toPubKeyInfo(hex.EncodeToString(pubKey), addrType, net)
+	if err != nil {
+		return privateKeyInfo{}, err
+	}
+	info.signer = signer
+	return info, nil
+}
+
+func toPubKeyInfo(pubKey string, addrType wallet.AddressType, net netchain.Net) (privateKeyInfo, error) {
+	pub, err := wallet.ParsePublicKey(pubKey)
+	if err != nil {
+		return privateKeyInfo{}, err
+	}
+	addr, err := wallet.AddressFromPublicKeyWithType(pubKey, net, addrType)
 	if err != nil {
 		return privateKeyInfo{}, err
 	}
@@ -279,7 +685,20 @@ func toPkInfo(privKey string, net netchain.Net) (privateKeyInfo, error) {
 	if err != nil {
 		return privateKeyInfo{}, err
 	}
-	return privateKeyInfo{key: privKey, address: addr, pkScript: pkScript}, nil
+	redeemScript, err := wallet.RedeemScriptFromPublicKey(pubKey, addrType)
+	if err != nil {
+		return privateKeyInfo{}, err
+	}
+	uncompressed := len(pubKey) != 2*btcec.PubKeyBytesLenCompressed
+	return privateKeyInfo{pubKey: pub, address: addr, pkScript: pkScript, uncompressed: uncompressed, redeemScript: redeemScript}, nil
+}
+
+// serializedPubKey returns the public key in the format the P2PKH address hashes it.
+func (info privateKeyInfo) serializedPubKey() []byte {
+	if info.uncompressed {
+		return info.pubKey.SerializeUncompressed()
+	}
+	return info.pubKey.SerializeCompressed()
 }
 
 type destinationInfo struct {
@@ -305,7 +724,8 @@ func calcBalanceOfAddresses(addresses []address) (balance int64) {
 	return
 }
 
-func chooseUTXOs(utxos []addressinfo.UTXO, amountToSend int64) (toSpend []addressinfo.UTXO, balance int64) {
+func chooseUTXOs(utxosOfAddr []addressinfo.UTXO, amountToSend int64) (toSpend []addressinfo.UTXO, balance int64, err error) {
+	utxos := append([]addressinfo.UTXO{}, utxosOfAddr...)
 	sort.Slice(utxos, func(i, j int) bool {
 		return utxos[i].Balance < utxos[j].Balance
 	})
@@ -314,18 +734,29 @@ func chooseUTXOs(utxos []addressinfo.UTXO, amountToSend int64) (toSpend []addres
 	for i, u := range utxos {
 		accBalance += u.Balance
 		if accBalance >= amountToSend {
-			return utxos[:i+1], accBalance
+			return utxos[:i+1], accBalance, nil
 		}
 	}
 
-	panic("address doesn't have enough bitcoins for transfer")
+	return nil, 0, &InsufficientFundsError{Needed: amountToSend, Available: accBalance}
+}
+
+// checkNet prevents the panics of netchain on the nets it doesn't know.
+func checkNet(net netchain.Net) error {
+	if net != netchain.MainNet && net != netchain.TestNet {
+		return fmt.Errorf("net chain '%s' is not supported", net)
+	}
+	return nil
 }
 
 func addressToPkScript(address string, net netchain.Net) ([]byte, error) {
 	// extracting address as []byte from function argument
 	destinationAddr, err := btcutil.DecodeAddress(address, net.GetBtcdNetParams())
 	if err != nil {
-		return nil, err
+		return nil, &InvalidAddressError{Address: address, Net: net, Err: err}
+	}
+	if !destinationAddr.IsForNet(net.GetBtcdNetParams()) {
+		return nil, &InvalidAddressError{Address: address, Net: net, Err: fmt.Errorf("address is of another net")}
 	}
 
 	destinationAddrByte, err := txscript.PayToAddrScript(destinationAddr)
@@ -335,41 +766,113 @@ func addressToPkScript(address string, net netchain.Net) ([]byte, error) {
 	return destinationAddrByte, nil
 }
 
-func signTx(tx *wire.MsgTx, addresses []address) error {
-	type utxoWithKey struct {
-		addressinfo.UTXO
-		wif *btcutil.WIF
-	}
+type utxoWithKey struct {
+	addressinfo.UTXO
+	pkInfo   privateKeyInfo
+	pkScript []byte
+}
 
+// utxosOfInputs returns the UTXO each input of the transaction spends.
+func utxosOfInputs(tx *wire.MsgTx, addresses []address) ([]utxoWithKey, error) {
 	utxosToSpendMap := make(map[string]utxoWithKey)
 	for _, a := range addresses {
-		wif, err := btcutil.DecodeWIF(a.privateKey)
-		if err != nil {
-			return err
-		}
 		for _, u := range a.UTXOs {
 			h, err := chainhash.NewHashFromStr(u.TxID)
 			if err != nil {
-				return fmt.Errorf("signing transaction failed, could compute hash utxo=%v", u)
+				return nil, fmt.Errorf("signing transaction failed, could compute hash utxo=%v", u)
 			}
-			utxosToSpendMap[h.String()+strconv.Itoa(u.TxOutIdx)] = utxoWithKey{UTXO: u, wif: wif}
+			utxosToSpendMap[h.String()+strconv.Itoa(u.TxOutIdx)] = utxoWithKey{UTXO: u, pkInfo: a.pkInfo}
 		}
 	}
 
+	utxosOfIns := make([]utxoWithKey, len(tx.TxIn))
 	for i, in := range tx.TxIn {
-		utxoOfIn := utxosToSpendMap[in.PreviousOutPoint.Hash.String()+strconv.Itoa(int(in.PreviousOutPoint.Index))]
+		utxoOfIn, ok := utxosToSpendMap[in.PreviousOutPoint.Hash.String()+strconv.Itoa(int(in.PreviousOutPoint.Index))]
+		if !ok {
+			return nil, fmt.Errorf("signing transaction failed, no UTXO for input %s", in.PreviousOutPoint)
+		}
 		sourcePkString, err := hex.DecodeString(utxoOfIn.Pbscript)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		signature, err := txscript.SignatureScript(tx, i, sourcePkString, txscript.SigHashAll, utxoOfIn.wif.PrivKey, false)
-		if err != nil {
-			return err
+		utxoOfIn.pkScript = sourcePkString
+		utxosOfIns[i] = utxoOfIn
+	}
+	return utxosOfIns, nil
+}
+
+func signTx(tx *wire.MsgTx, addresses []address) error {
+	utxosOfIns, err := utxosOfInputs(tx, addresses)
+	if err != nil {
+		return err
+	}
+
+	// Taproot signatures commit to the amounts and scripts of all the inputs
+	prevOuts := make(map[wire.OutPoint]*wire.TxOut)
+	for i, in := range tx.TxIn {
+		prevOuts[in.PreviousOutPoint] = wire.NewTxOut(utxosOfIns[i].Balance, utxosOfIns[i].pkScript)
+	}
+
+	// BIP143 and BIP341 midstate, shared by all the witness inputs
+	fetcher := txscript.NewMultiPrevOutFetcher(prevOuts)
+	sigHashes := txscript.NewTxSigHashes(tx, fetcher)
+	for i, in := range tx.TxIn {
+		utxoOfIn := utxosOfIns[i]
+		if utxoOfIn.pkInfo.multisigScript() != nil {
+			err := signMultisigInput(tx, sigHashes, i, utxoOfIn)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		sourcePkString := utxoOfIn.pkScript
+		signer := utxoOfIn.pkInfo.signer
+		switch {
+		case txscript.IsPayToTaproot(sourcePkString):
+			// key path spending, the private key is tweaked the same way as the address
+			sig, err := rawTxInTaprootSignature(tx, sigHashes, i, utxoOfIn.Balance, sourcePkString, signer)
+			if err != nil {
+				return err
+			}
+			in.Witness = wire.TxWitness{sig}
+		case txscript.IsPayToWitnessPubKeyHash(sourcePkString):
+			// SegWit requires the compressed public key and commits to the amount being spent
+			sig, err := rawTxInWitnessSignature(tx, sigHashes, i, utxoOfIn.Balance, sourcePkString, signer)
+			if err != nil {
+				return err
+			}
+			in.Witness = wire.TxWitness{sig, signer.PublicKey().SerializeCompressed()}
+		case txscript.IsPayToScriptHash(sourcePkString):
+			redeemScript := utxoOfIn.pkInfo.redeemScript
+			if !txscript.IsPayToWitnessPubKeyHash(redeemScript) {
+				return fmt.Errorf("couldn't spend P2SH output %s:%d, its redeem script is unknown", utxoOfIn.TxID, utxoOfIn.TxOutIdx)
+			}
+			// nested SegWit is signed as P2WPKH and reveals the witness program in the signature script
+			sig, err := rawTxInWitnessSignature(tx, sigHashes, i, utxoOfIn.Balance, redeemScript, signer)
+			if err != nil {
+				return err
+			}
+			sigScript, err := txscript.NewScriptBuilder().AddData(redeemScript).Script()
+			if err != nil {
+				return err
+			}
+			in.SignatureScript = sigScript
+			in.Witness = wire.TxWitness{sig, signer.PublicKey().SerializeCompressed()}
+		default:
+			sig, err := rawTxInSignature(tx, i, sourcePkString, signer)
+			if err != nil {
+				return err
+			}
+			sigScript, err := txscript.NewScriptBuilder().AddData(sig).AddData(utxoOfIn.pkInfo.serializedPubKey()).Script()
+			if err != nil {
+				return err
+			}
+			in.SignatureScript = sigScript
 		}
-		in.SignatureScript = signature
 	}
 
-	return nil
+	// a wrong script of the provider would make the transaction invalid
+	return verifyTx(tx, fetcher)
 }
 
 func hexEncodeTx(tx *wire.MsgTx) (string, error) {
@@ -1,9 +1,14 @@
 package txutil
 
 import (
+	"bytes"
+	"errors"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/glossd/btc/addressinfo"
 	"github.com/glossd/btc/netchain"
+	"github.com/glossd/btc/wallet"
 	"github.com/stretchr/testify/assert"
 	"testing"
 )
@@ -14,7 +19,7 @@ const privateKey3 = "93UVjiGYyB6q16iMPuKjYePdLesaYvdMyP3EjE1PjZEqzd456h1"
 
 // destination of each private key
 const destination1 = "mgFv6afUVhrdd3D6mY2iyWzHVk5b64qTok"
-const destination2 = "n4kkk9H2jGj7t8LA4vxK4DHM7Lq95VaEXC"
+const destination2 = "mw9ASiaxfo2HZjEVV6u5x7x7DoZzu6ykHT"
 const destination3 = "mwRL1TpsRSFy5KXbxEd2KrHiD16VvbbAdj"
 
 func TestCreate_SendAll(t *testing.T) {
@@ -65,8 +70,8 @@ func TestCreate_AutoMinerFee(t *testing.T) {
 	tx := decodeTx(t, rawTx)
 	assert.EqualValues(t, 1, len(tx.TxIn))
 	assert.EqualValues(t, 2, len(tx.TxOut))
-	assert.EqualValues(t, tx.TxOut[0].Value, amount)
-	assert.EqualValues(t, tx.TxOut[1].Value, 497430) // this number shouldn't change over time, the bytes of transaction should stay the same
+	assert.EqualValues(t, outputTo(t, tx, addressPkScript(t, destination2)).Value, amount)
+	assert.EqualValues(t, outputTo(t, tx, addressPkScript(t, destination1)).Value, 497410) // this number shouldn't change over time, the estimated size of 259 vbytes should stay the same
 }
 
 func TestCreate_MultiplePrivateKeys(t *testing.T) {
@@ -97,9 +102,8 @@ func TestCreate_MultiplePrivateKeys(t *testing.T) {
 		tx := decodeTx(t, rawTx)
 		assert.EqualValues(t, 2, len(tx.TxIn))
 		assert.EqualValues(t, 2, len(tx.TxOut))
-		assert.EqualValues(t, amount, tx.TxOut[0].Value)
-		assert.EqualValues(t, addressinfo.MockAddressBalance/2, tx.TxOut[1].Value)
-		assert.EqualValues(t, tx.TxOut[1].PkScript, addressPkScript(t, destination2))
+		assert.EqualValues(t, amount, outputTo(t, tx, addressPkScript(t, destination3)).Value)
+		assert.EqualValues(t, addressinfo.MockAddressBalance/2, outputTo(t, tx, addressPkScript(t, destination2)).Value)
 	})
 }
 
@@ -116,6 +120,179 @@ func TestCreate_ToMultipleDestinations(t *testing.T) {
 	assert.EqualValues(t, 3, len(tx.TxOut))
 }
 
+func TestCreate_SegWit(t *testing.T) {
+	for _, addrType := range []wallet.AddressType{wallet.P2WPKH, wallet.P2SHP2WPKH, wallet.P2TR} {
+		t.Run(addrType.String(), func(t *testing.T) {
+			var amount int64 = 5e5
+			rawTx, err := Create(CreateParams{
+				PrivateKey:  privateKey2,
+				AddressType: addrType,
+				Destination: destination1,
+				Amount:      amount,
+				Fetch:       addressinfo.FetchMock,
+				Net:         netchain.TestNet,
+			})
+			assert.Nil(t, err)
+
+			segwitAddr, err := wallet.AddressFromPrivateKeyWithType(privateKey2, netchain.TestNet, addrType)
+			assert.Nil(t, err)
+			tx := decodeTx(t, rawTx)
+			assert.EqualValues(t, 1, len(tx.TxIn))
+			assert.NotEmpty(t, tx.TxIn[0].Witness)
+			assert.EqualValues(t, 2, len(tx.TxOut))
+			assert.EqualValues(t, amount, outputTo(t, tx, addressPkScript(t, destination1)).Value)
+			assert.NotNil(t, outputTo(t, tx, addressPkScript(t, segwitAddr)))
+			verifyInput(t, tx, 0, addressPkScript(t, segwitAddr), addressinfo.MockAddressBalance)
+		})
+	}
+}
+
+func TestCreate_CompressedKey(t *testing.T) {
+	for _, key := range []struct {
+		privateKey string
+		address    string
+		pubKeySize int
+	}{{privateKey1, destination1, 65}, {privateKey2, destination2, 33}} {
+		t.Run(key.address, func(t *testing.T) {
+			result, err := CreateWithResult(CreateParams{
+				PrivateKey:  key.privateKey,
+				Destination: destination3,
+				Amount:      5e5,
+				FeeRate:     10,
+				Fetch:       addressinfo.FetchMock,
+				Net:         netchain.TestNet,
+			})
+			assert.Nil(t, err)
+
+			tx := decodeTx(t, result.RawTx)
+			pushes, err := txscript.PushedData(tx.TxIn[0].SignatureScript)
+			assert.Nil(t, err)
+			assert.EqualValues(t, key.pubKeySize, len(pushes[1]))
+			verifyInput(t, tx, 0, addressPkScript(t, key.address), addressinfo.MockAddressBalance)
+			// the estimate counts the public key of the WIF, the signature may be up to 2 bytes shorter
+			assert.GreaterOrEqual(t, result.Fee, 10*virtualSize(tx))
+			assert.LessOrEqual(t, result.Fee, 10*(virtualSize(tx)+2))
+		})
+	}
+}
+
+func TestCreate_ToTaprootDestination(t *testing.T) {
+	taprootAddr, err := wallet.AddressFromPrivateKeyWithType(privateKey1, netchain.TestNet, wallet.P2TR)
+	assert.Nil(t, err)
+	rawTx, err := Create(CreateParams{
+		PrivateKey:  privateKey1,
+		Destination: taprootAddr,
+		Amount:      5e5,
+		Fetch:       addressinfo.FetchMock,
+		Net:         netchain.TestNet,
+	})
+	assert.Nil(t, err)
+
+	tx := decodeTx(t, rawTx)
+	assert.True(t, txscript.IsPayToTaproot(outputTo(t, tx, addressPkScript(t, taprootAddr)).PkScript))
+}
+
+func TestCreate_ChangeAddress(t *testing.T) {
+	changeAddr, err := wallet.AddressFromPrivateKeyWithType(privateKey3, netchain.TestNet, wallet.P2WPKH)
+	assert.Nil(t, err)
+	rawTx, err := Create(CreateParams{
+		PrivateKey:    privateKey1,
+		Destination:   destination2,
+		Amount:        5e5,
+		ChangeAddress: changeAddr,
+		Fetch:         addressinfo.FetchMock,
+		Net:           netchain.TestNet,
+	})
+	assert.Nil(t, err)
+	tx := decodeTx(t, rawTx)
+	assert.EqualValues(t, 2, len(tx.TxOut))
+	assert.EqualValues(t, addressinfo.MockAddressBalance-5e5-DefaultMinerFee, outputTo(t, tx, addressPkScript(t, changeAddr)).Value)
+
+	_, err = Create(CreateParams{
+		PrivateKey:    privateKey1,
+		Destination:   destination2,
+		Amount:        5e5,
+		ChangeAddress: "invalid",
+		Fetch:         addressinfo.FetchMock,
+		Net:           netchain.TestNet,
+	})
+	assert.NotNil(t, err)
+}
+
+func TestCreate_RandomOutputOrder(t *testing.T) {
+	changePositions := make(map[int]bool)
+	for i := 0; i < 50 && len(changePositions) < 2; i++ {
+		rawTx, err := Create(CreateParams{
+			PrivateKey:  privateKey1,
+			Destination: destination2,
+			Amount:      5e5,
+			Fetch:       addressinfo.FetchMock,
+			Net:         netchain.TestNet,
+		})
+		assert.Nil(t, err)
+		for idx, out := range decodeTx(t, rawTx).TxOut {
+			if bytes.Equal(out.PkScript, addressPkScript(t, destination1)) {
+				changePositions[idx] = true
+			}
+		}
+	}
+	assert.EqualValues(t, 2, len(changePositions), "the change must be both first and last")
+}
+
+func TestCreate_DataOutputs(t *testing.T) {
+	docHash := chainhash.HashB([]byte("invoice #42"))
+	t.Run("FeeRate", func(t *testing.T) {
+		rawTx, err := Create(CreateParams{
+			PrivateKey:  privateKey2,
+			AddressType: wallet.P2WPKH,
+			Destination: destination1,
+			Amount:      5e5,
+			DataOutputs: [][]byte{docHash},
+			FeeRate:     10,
+			Fetch:       addressinfo.FetchMock,
+			Net:         netchain.TestNet,
+		})
+		assert.Nil(t, err)
+		tx := decodeTx(t, rawTx)
+		assert.EqualValues(t, 3, len(tx.TxOut))
+		nullData, err := txscript.NullDataScript(docHash)
+		assert.Nil(t, err)
+		out := outputTo(t, tx, nullData)
+		assert.EqualValues(t, 0, out.Value)
+		pushes, err := txscript.PushedData(out.PkScript)
+		assert.Nil(t, err)
+		assert.EqualValues(t, [][]byte{docHash}, pushes)
+		assert.GreaterOrEqual(t, addressinfo.MockAddressBalance-sumOutputs(tx.TxOut), 10*virtualSize(tx))
+	})
+	t.Run("SendAll", func(t *testing.T) {
+		rawTx, err := Create(CreateParams{
+			PrivateKey:  privateKey1,
+			Destination: destination2,
+			SendAll:     true,
+			DataOutputs: [][]byte{docHash},
+			Fetch:       addressinfo.FetchMock,
+			Net:         netchain.TestNet,
+		})
+		assert.Nil(t, err)
+		tx := decodeTx(t, rawTx)
+		assert.EqualValues(t, 2, len(tx.TxOut))
+		assert.EqualValues(t, addressinfo.MockAddressBalance-DefaultMinerFee, outputTo(t, tx, addressPkScript(t, destination2)).Value)
+	})
+	t.Run("TooLarge", func(t *testing.T) {
+		for _, data := range [][][]byte{{make([]byte, 81)}, {make([]byte, 40), make([]byte, 40)}, {[]byte("order-42"), docHash}} {
+			_, err := Create(CreateParams{
+				PrivateKey:  privateKey1,
+				Destination: destination2,
+				Amount:      5e5,
+				DataOutputs: data,
+				Fetch:       addressinfo.FetchMock,
+				Net:         netchain.TestNet,
+			})
+			assert.True(t, errors.Is(err, ErrDataTooLarge))
+		}
+	})
+}
+
 func TestCreate_Validation(t *testing.T) {
 	type test struct {
 		input CreateParams
@@ -136,6 +313,9 @@ func TestCreate_Validation(t *testing.T) {
 		{input: CreateParams{PrivateKey: privateKey1, Destinations: dests(destination2, destination3), SendAll: true}},
 		{input: CreateParams{PrivateKey: privateKey1, Destinations: []Destination{{Address: destination2}}}},
 		{input: CreateParams{PrivateKey: privateKey1, Destinations: []Destination{{Amount: okAmount}}}},
+		{input: CreateParams{PrivateKey: privateKey1, Destination: destination2, Amount: okAmount, AddressType: "p2unknown"}},
+		{input: CreateParams{PrivateKey: privateKey1, Destination: destination2, Amount: okAmount, MinerFee: -1000}},
+		{input: CreateParams{PrivateKey: privateKey1, Destinations: []Destination{{Address: destination2, Amount: okAmount, SubtractFee: true}}, MinerFee: -1000}},
 	}
 
 	for _, test := range shouldntPass {
@@ -161,6 +341,17 @@ func TestCreate_Validation(t *testing.T) {
 	}
 }
 
+// outputTo returns the output paying to the script, the outputs of Create are in random order.
+func outputTo(t *testing.T, tx *wire.MsgTx, pkScript []byte) *wire.TxOut {
+	for _, out := range tx.TxOut {
+		if bytes.Equal(out.PkScript, pkScript) {
+			return out
+		}
+	}
+	t.Fatalf("transaction %s doesn't pay to %x", tx.TxHash(), pkScript)
+	return nil
+}
+
 func decodeTx(t *testing.T, rawTx string) *wire.MsgTx {
 	tx, err := hexDecodeTx(rawTx)
 	assert.Nil(t, err)
@@ -172,3 +363,10 @@ func addressPkScript(t *testing.T, address string) []byte {
 	assert.Nil(t, err)
 	return script
 }
+
+func verifyInput(t *testing.T, tx *wire.MsgTx, idx int, pkScript []byte, amount int64) {
+	fetcher := txscript.NewCannedPrevOutputFetcher(pkScript, amount)
+	vm, err := txscript.NewEngine(pkScript, tx, idx, txscript.StandardVerifyFlags, nil, txscript.NewTxSigHashes(tx, fetcher), amount, fetcher)
+	assert.Nil(t, err)
+	assert.Nil(t, vm.Execute())
+}
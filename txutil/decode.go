@@ -0,0 +1,131 @@
+package txutil
+
+import (
+	"encoding/hex"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/glossd/btc/addressinfo"
+	"github.com/glossd/btc/netchain"
+)
+
+// DecodedTx describes the raw transaction for reviewing it before broadcasting.
+type DecodedTx struct {
+	// Hash of the transaction without the witness, its id on blockchain.
+	TxID string
+	// Hash of the transaction with the witness, same as TxID for transactions without witness inputs.
+	WTxID    string
+	Version  int32
+	LockTime uint32
+	Inputs   []DecodedInput
+	Outputs  []DecodedOutput
+	// In bytes.
+	Size int64
+	// In vbytes, the size the fee rate is paid for.
+	VSize int64
+	// In weight units, a non-witness byte weighs 4 and a witness byte weighs 1.
+	Weight int64
+	// In satoshi, only set by DecodeWithFee.
+	Fee int64
+	// In sat/vB, only set by DecodeWithFee.
+	FeeRate float64
+}
+
+// DecodedInput describes the input and the output it spends.
+type DecodedInput struct {
+	// Hash of the transaction of the spent output.
+	TxID string
+	// Index of the spent output in its transaction.
+	TxOutIdx int
+	Sequence uint32
+	// The spent output, only set by DecodeWithFee.
+	Prevout *DecodedOutput
+}
+
+// DecodedOutput describes the receiver of the output.
+type DecodedOutput struct {
+	// In satoshi.
+	Value int64
+	// Bitcoin address of the receiver, empty for the scripts without the address e.g. OP_RETURN.
+	Address string
+	// Type of the script, e.g. pubkeyhash, witness_v0_keyhash, witness_v1_taproot, multisig or nulldata.
+	ScriptType string
+	// Hex-encoded script.
+	PkScript string
+}
+
+// Decode describes the hex-encoded transaction without fetching anything.
+// The net defaults to netchain.MainNet.
+func Decode(rawTx string, net netchain.Net) (DecodedTx, error) {
+	if net == "" {
+		net = netchain.MainNet
+	}
+	if err := checkNet(net); err != nil {
+		return DecodedTx{}, err
+	}
+	tx, err := hexDecodeTx(rawTx)
+	if err != nil {
+		return DecodedTx{}, err
+	}
+	result := DecodedTx{
+		TxID:     tx.TxHash().String(),
+		WTxID:    tx.WitnessHash().String(),
+		Version:  tx.Version,
+		LockTime: tx.LockTime,
+		Size:     int64(tx.SerializeSize()),
+		VSize:    virtualSize(tx),
+		Weight:   txWeight(tx),
+	}
+	for _, in := range tx.TxIn {
+		result.Inputs = append(result.Inputs, DecodedInput{
+			TxID:     in.PreviousOutPoint.Hash.String(),
+			TxOutIdx: int(in.PreviousOutPoint.Index),
+			Sequence: in.Sequence,
+		})
+	}
+	for _, out := range tx.TxOut {
+		result.Outputs = append(result.Outputs, decodeOutput(out, net))
+	}
+	return result, nil
+}
+
+// DecodeWithFee works as Decode and fetches the transactions of the spent outputs to compute the fee.
+// The net defaults to netchain.MainNet, fetchRawTx to addressinfo.FetchRawTxFromBlockcypher.
+func DecodeWithFee(rawTx string, net netchain.Net, fetchRawTx addressinfo.FetchRawTx) (DecodedTx, error) {
+	if net == "" {
+		net = netchain.MainNet
+	}
+	result, err := Decode(rawTx, net)
+	if err != nil {
+		return DecodedTx{}, err
+	}
+	if fetchRawTx == nil {
+		fetchRawTx = addressinfo.FetchRawTxFromBlockcypher
+	}
+	tx, err := hexDecodeTx(rawTx)
+	if err != nil {
+		return DecodedTx{}, err
+	}
+	var inputsValue int64
+	for i, in := range tx.TxIn {
+		prevTx, err := fetchPrevTx(in.PreviousOutPoint, fetchRawTx, net)
+		if err != nil {
+			return DecodedTx{}, err
+		}
+		prevOut := decodeOutput(prevTx.TxOut[in.PreviousOutPoint.Index], net)
+		result.Inputs[i].Prevout = &prevOut
+		inputsValue += prevOut.Value
+	}
+	result.Fee = inputsValue - sumOutputs(tx.TxOut)
+	result.FeeRate = float64(result.Fee) / float64(result.VSize)
+	return result, nil
+}
+
+func decodeOutput(out *wire.TxOut, net netchain.Net) DecodedOutput {
+	class, addrs, _, _ := txscript.ExtractPkScriptAddrs(out.PkScript, net.GetBtcdNetParams())
+	result := DecodedOutput{Value: out.Value, ScriptType: class.String(), PkScript: hex.EncodeToString(out.PkScript)}
+	// bare multisig scripts have public keys but no address
+	if len(addrs) == 1 && class != txscript.MultiSigTy {
+		result.Address = addrs[0].EncodeAddress()
+	}
+	return result
+}
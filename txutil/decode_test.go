@@ -0,0 +1,71 @@
+package txutil
+
+import (
+	"github.com/glossd/btc/addressinfo"
+	"github.com/glossd/btc/netchain"
+	"github.com/glossd/btc/wallet"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	segwitAddr, err := wallet.AddressFromPrivateKeyWithType(privateKey2, netchain.TestNet, wallet.P2WPKH)
+	assert.Nil(t, err)
+	fetch, fetchRawTx := fetchMockWithPrevTxs(t)
+	result, err := CreateWithResult(CreateParams{
+		PrivateKey:  privateKey2,
+		AddressType: wallet.P2WPKH,
+		Destination: destination1,
+		Amount:      5e5,
+		DataOutputs: [][]byte{[]byte("order-42")},
+		FeeRate:     5,
+		RBF:         true,
+		Fetch:       fetch,
+		Net:         netchain.TestNet,
+	})
+	assert.Nil(t, err)
+	tx := decodeTx(t, result.RawTx)
+
+	decoded, err := Decode(result.RawTx, netchain.TestNet)
+	assert.Nil(t, err)
+	assert.EqualValues(t, tx.TxHash().String(), decoded.TxID)
+	assert.NotEqual(t, decoded.TxID, decoded.WTxID)
+	assert.EqualValues(t, 1, decoded.Version)
+	assert.EqualValues(t, 1, len(decoded.Inputs))
+	assert.EqualValues(t, tx.TxIn[0].PreviousOutPoint.Hash.String(), decoded.Inputs[0].TxID)
+	assert.EqualValues(t, rbfSequence, decoded.Inputs[0].Sequence)
+	assert.Nil(t, decoded.Inputs[0].Prevout)
+	assert.EqualValues(t, virtualSize(tx), decoded.VSize)
+	assert.Less(t, decoded.VSize, decoded.Size)
+	assert.EqualValues(t, int64(tx.SerializeSizeStripped()*3)+decoded.Size, decoded.Weight)
+	assert.EqualValues(t, 0, decoded.Fee)
+
+	outputs := make(map[string]DecodedOutput)
+	for _, out := range decoded.Outputs {
+		outputs[out.ScriptType] = out
+	}
+	assert.EqualValues(t, destination1, outputs["pubkeyhash"].Address)
+	assert.EqualValues(t, 5e5, outputs["pubkeyhash"].Value)
+	assert.EqualValues(t, segwitAddr, outputs["witness_v0_keyhash"].Address)
+	assert.EqualValues(t, "", outputs["nulldata"].Address)
+
+	withFee, err := DecodeWithFee(result.RawTx, netchain.TestNet, fetchRawTx)
+	assert.Nil(t, err)
+	assert.EqualValues(t, result.Fee, withFee.Fee)
+	assert.GreaterOrEqual(t, withFee.FeeRate, 5.0)
+	assert.EqualValues(t, segwitAddr, withFee.Inputs[0].Prevout.Address)
+	assert.EqualValues(t, addressinfo.MockAddressBalance, withFee.Inputs[0].Prevout.Value)
+
+	mainnet, err := Decode(result.RawTx, "")
+	assert.Nil(t, err)
+	mainnetAddr, err := wallet.AddressFromPrivateKeyWithType(privateKey2, netchain.MainNet, wallet.P2WPKH)
+	assert.Nil(t, err)
+	for _, out := range mainnet.Outputs {
+		if out.ScriptType == "witness_v0_keyhash" {
+			assert.EqualValues(t, mainnetAddr, out.Address)
+		}
+	}
+
+	_, err = Decode("00", netchain.TestNet)
+	assert.NotNil(t, err)
+}
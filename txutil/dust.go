@@ -0,0 +1,32 @@
+package txutil
+
+import (
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// DefaultDustRelayFee in sat/vB is the fee rate which makes an output dust if spending it costs more than it's worth,
+// the default of Bitcoin Core.
+const DefaultDustRelayFee = 3
+
+// DustLimit returns the smallest amount in satoshi the output with the script can have to be relayed,
+// e.g. 546 for P2PKH and 294 for P2WPKH at the DefaultDustRelayFee. Unspendable OP_RETURN outputs have no limit.
+func DustLimit(pkScript []byte, dustRelayFee int64) int64 {
+	if txscript.GetScriptClass(pkScript) == txscript.NullDataTy {
+		return 0
+	}
+	// the output itself and the input spending it, as Bitcoin Core counts them
+	size := 8 + wire.VarIntSerializeSize(uint64(len(pkScript))) + len(pkScript)
+	if txscript.IsWitnessProgram(pkScript) {
+		// outpoint, sequence, empty signature script and the discounted witness of a signature and a public key
+		size += 32 + 4 + 1 + 107/4 + 4
+	} else {
+		size += 32 + 4 + 1 + 107 + 4
+	}
+	return int64(size) * dustRelayFee
+}
+
+// isDust tells whether the output would be rejected by the nodes.
+func isDust(value int64, pkScript []byte, dustRelayFee int64) bool {
+	return value < DustLimit(pkScript, dustRelayFee)
+}
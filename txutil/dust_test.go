@@ -0,0 +1,88 @@
+package txutil
+
+import (
+	"errors"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/glossd/btc/addressinfo"
+	"github.com/glossd/btc/netchain"
+	"github.com/glossd/btc/wallet"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDustLimit(t *testing.T) {
+	addressOfType := func(addrType wallet.AddressType) string {
+		addr, err := wallet.AddressFromPrivateKeyWithType(privateKey2, netchain.TestNet, addrType)
+		assert.Nil(t, err)
+		return addr
+	}
+	assert.EqualValues(t, 546, DustLimit(addressPkScript(t, destination1), DefaultDustRelayFee))
+	assert.EqualValues(t, 294, DustLimit(addressPkScript(t, addressOfType(wallet.P2WPKH)), DefaultDustRelayFee))
+	assert.EqualValues(t, 540, DustLimit(addressPkScript(t, addressOfType(wallet.P2SHP2WPKH)), DefaultDustRelayFee))
+	assert.EqualValues(t, 330, DustLimit(addressPkScript(t, addressOfType(wallet.P2TR)), DefaultDustRelayFee))
+	nullData, err := txscript.NullDataScript([]byte("hello"))
+	assert.Nil(t, err)
+	assert.EqualValues(t, 0, DustLimit(nullData, DefaultDustRelayFee))
+}
+
+func TestCreateWithResult_DustChange(t *testing.T) {
+	t.Run("MinerFee", func(t *testing.T) {
+		result, err := CreateWithResult(CreateParams{
+			PrivateKey:  privateKey1,
+			Destination: destination2,
+			Amount:      addressinfo.MockAddressBalance - DefaultMinerFee - 100,
+			Fetch:       addressinfo.FetchMock,
+			Net:         netchain.TestNet,
+		})
+		assert.Nil(t, err)
+		assert.EqualValues(t, 1, len(decodeTx(t, result.RawTx).TxOut))
+		assert.EqualValues(t, DefaultMinerFee+100, result.Fee)
+		assert.EqualValues(t, 100, result.DustChange)
+	})
+	t.Run("FeeRate", func(t *testing.T) {
+		// a P2WPKH input and a P2PKH output are 113 vbytes
+		result, err := CreateWithResult(CreateParams{
+			PrivateKey:  privateKey2,
+			AddressType: wallet.P2WPKH,
+			Destination: destination1,
+			Amount:      addressinfo.MockAddressBalance - 1130 - 100,
+			FeeRate:     10,
+			Fetch:       addressinfo.FetchMock,
+			Net:         netchain.TestNet,
+		})
+		assert.Nil(t, err)
+		assert.EqualValues(t, 1, len(decodeTx(t, result.RawTx).TxOut))
+		assert.EqualValues(t, 1230, result.Fee)
+		assert.EqualValues(t, 100, result.DustChange)
+	})
+	t.Run("NoDust", func(t *testing.T) {
+		result, err := CreateWithResult(CreateParams{
+			PrivateKey:  privateKey1,
+			Destination: destination2,
+			Amount:      5e5,
+			Fetch:       addressinfo.FetchMock,
+			Net:         netchain.TestNet,
+		})
+		assert.Nil(t, err)
+		assert.EqualValues(t, 2, len(decodeTx(t, result.RawTx).TxOut))
+		assert.EqualValues(t, DefaultMinerFee, result.Fee)
+		assert.EqualValues(t, 0, result.DustChange)
+	})
+}
+
+func TestCreate_DustDestination(t *testing.T) {
+	segwitAddr, err := wallet.AddressFromPrivateKeyWithType(privateKey3, netchain.TestNet, wallet.P2WPKH)
+	assert.Nil(t, err)
+	create := func(dest string) error {
+		_, err := Create(CreateParams{
+			PrivateKey:  privateKey1,
+			Destination: dest,
+			Amount:      300,
+			Fetch:       addressinfo.FetchMock,
+			Net:         netchain.TestNet,
+		})
+		return err
+	}
+	assert.Nil(t, create(segwitAddr), "P2WPKH limit is 294")
+	assert.True(t, errors.Is(create(destination3), ErrDustOutput), "P2PKH limit is 546")
+}
@@ -0,0 +1,73 @@
+package txutil
+
+import (
+	"errors"
+	"fmt"
+	"github.com/glossd/btc/netchain"
+)
+
+var (
+	// ErrInsufficientFunds matches InsufficientFundsError with errors.Is.
+	ErrInsufficientFunds = errors.New("not enough satoshi")
+	// ErrDustOutput is returned when an output is too small for the nodes to relay it.
+	ErrDustOutput = errors.New("output is below the dust limit")
+	// ErrFeeTooHigh matches FeeTooHighError with errors.Is.
+	ErrFeeTooHigh = errors.New("miner fee is too high")
+	// ErrInvalidAddress matches InvalidAddressError with errors.Is.
+	ErrInvalidAddress = errors.New("invalid address")
+	// ErrDataTooLarge is returned when the OP_RETURN outputs exceed the size or the number the nodes relay.
+	ErrDataTooLarge = errors.New("OP_RETURN data is too large")
+	// ErrInvalidSignature is returned when an input doesn't validate against the script of the output it spends.
+	ErrInvalidSignature = errors.New("input doesn't validate")
+)
+
+// InsufficientFundsError is returned when the UTXOs can't pay for the outputs and the fee.
+type InsufficientFundsError struct {
+	// In satoshi, the outputs with the fee.
+	Needed int64
+	// In satoshi.
+	Available int64
+}
+
+func (e *InsufficientFundsError) Error() string {
+	return fmt.Sprintf("not enough satoshi, needed=%d, available=%d", e.Needed, e.Available)
+}
+
+func (e *InsufficientFundsError) Is(target error) bool {
+	return target == ErrInsufficientFunds
+}
+
+// FeeTooHighError is returned when the automatically calculated fee exceeds the limit.
+type FeeTooHighError struct {
+	// In satoshi.
+	Max int64
+	// In satoshi.
+	Got int64
+}
+
+func (e *FeeTooHighError) Error() string {
+	return fmt.Sprintf("the maximum auto miner fee is reached, max=%d, got=%d", e.Max, e.Got)
+}
+
+func (e *FeeTooHighError) Is(target error) bool {
+	return target == ErrFeeTooHigh
+}
+
+// InvalidAddressError is returned when the address can't be decoded or belongs to another net.
+type InvalidAddressError struct {
+	Address string
+	Net     netchain.Net
+	Err     error
+}
+
+func (e *InvalidAddressError) Error() string {
+	return fmt.Sprintf("address %s is invalid on %s: %s", e.Address, e.Net, e.Err)
+}
+
+func (e *InvalidAddressError) Unwrap() error {
+	return e.Err
+}
+
+func (e *InvalidAddressError) Is(target error) bool {
+	return target == ErrInvalidAddress
+}
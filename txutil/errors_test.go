@@ -0,0 +1,70 @@
+package txutil
+
+import (
+	"errors"
+	"github.com/glossd/btc/addressinfo"
+	"github.com/glossd/btc/netchain"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCreate_Errors(t *testing.T) {
+	params := CreateParams{
+		PrivateKey:  privateKey1,
+		Destination: destination2,
+		Amount:      5e5,
+		Fetch:       addressinfo.FetchMock,
+		Net:         netchain.TestNet,
+	}
+	t.Run("InsufficientFunds", func(t *testing.T) {
+		p := params
+		p.Amount = addressinfo.MockAddressBalance
+		_, err := Create(p)
+		assert.True(t, errors.Is(err, ErrInsufficientFunds))
+		var fundsErr *InsufficientFundsError
+		assert.True(t, errors.As(err, &fundsErr))
+		assert.EqualValues(t, addressinfo.MockAddressBalance+DefaultMinerFee, fundsErr.Needed)
+		assert.EqualValues(t, addressinfo.MockAddressBalance, fundsErr.Available)
+	})
+	t.Run("DustOutput", func(t *testing.T) {
+		p := params
+		p.Amount = 100
+		_, err := Create(p)
+		assert.True(t, errors.Is(err, ErrDustOutput))
+	})
+	t.Run("FeeTooHigh", func(t *testing.T) {
+		p := params
+		p.AutoMinerFee = true
+		p.GetSatoshiPerByte = func(net netchain.Net) (int, error) {
+			return 1000, nil
+		}
+		_, err := Create(p)
+		assert.True(t, errors.Is(err, ErrFeeTooHigh))
+		var feeErr *FeeTooHighError
+		assert.True(t, errors.As(err, &feeErr))
+		assert.EqualValues(t, maxMinerFee, feeErr.Max)
+	})
+	t.Run("InvalidAddress", func(t *testing.T) {
+		p := params
+		// mainnet address
+		p.Destination = "1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2"
+		_, err := Create(p)
+		assert.True(t, errors.Is(err, ErrInvalidAddress))
+		var addrErr *InvalidAddressError
+		assert.True(t, errors.As(err, &addrErr))
+		assert.EqualValues(t, netchain.TestNet, addrErr.Net)
+	})
+	t.Run("UnsupportedNet", func(t *testing.T) {
+		p := params
+		p.Net = "regtest"
+		_, err := Create(p)
+		assert.NotNil(t, err)
+	})
+}
+
+func TestChooseUTXOs_NotEnough(t *testing.T) {
+	_, _, err := chooseUTXOs([]addressinfo.UTXO{{Balance: 1}, {Balance: 2}}, 4)
+	var fundsErr *InsufficientFundsError
+	assert.True(t, errors.As(err, &fundsErr))
+	assert.EqualValues(t, 3, fundsErr.Available)
+}
@@ -0,0 +1,306 @@
+package txutil
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/glossd/btc/netchain"
+	"github.com/glossd/btc/wallet"
+	"sort"
+)
+
+// Multisig describes the M-of-N multisig wallet of the co-signers.
+type Multisig struct {
+	// Number of the signatures spending requires.
+	Required int
+	// Hex-encoded public keys of all the co-signers in any order, they are sorted by BIP67.
+	PublicKeys []string
+}
+
+func toMultisigInfo(m Multisig, signers []Signer, addrType wallet.AddressType, net netchain.Net) (privateKeyInfo, error) {
+	script, err := wallet.MultisigScript(m.Required, m.PublicKeys)
+	if err != nil {
+		return privateKeyInfo{}, err
+	}
+	addr, err := wallet.MultisigAddress(m.Required, m.PublicKeys, net, addrType)
+	if err != nil {
+		return privateKeyInfo{}, err
+	}
+	pkScript, err := addressToPkScript(addr, net)
+	if err != nil {
+		return privateKeyInfo{}, err
+	}
+	redeemScript, err := wallet.MultisigRedeemScript(m.Required, m.PublicKeys, addrType)
+	if err != nil {
+		return privateKeyInfo{}, err
+	}
+	info := privateKeyInfo{address: addr, pkScript: pkScript, redeemScript: redeemScript}
+	if addrType != wallet.P2SH {
+		info.witnessScript = script
+	}
+
+	for _, signer := range signers {
+		if !isCosigner(script, signer) {
+			return privateKeyInfo{}, fmt.Errorf("private key of %x isn't a co-signer of the multisig", signer.PublicKey().SerializeCompressed())
+		}
+		info.cosigners = append(info.cosigners, signer)
+	}
+	// the signatures go in the order of the public keys in the script
+	sort.Slice(info.cosigners, func(i, j int) bool {
+		return bytes.Compare(info.cosigners[i].PublicKey().SerializeCompressed(), info.cosigners[j].PublicKey().SerializeCompressed()) < 0
+	})
+	for i := 1; i < len(info.cosigners); i++ {
+		if info.cosigners[i-1].PublicKey().IsEqual(info.cosigners[i].PublicKey()) {
+			return privateKeyInfo{}, fmt.Errorf("private key of %x is specified twice", info.cosigners[i].PublicKey().SerializeCompressed())
+		}
+	}
+	return info, nil
+}
+
+// multisigScript returns the M-of-N script the address locks the bitcoins with, nil for single keys.
+func (info privateKeyInfo) multisigScript() []byte {
+	return multisigScriptOf(info.redeemScript, info.witnessScript)
+}
+
+func multisigScriptOf(redeemScript, witnessScript []byte) []byte {
+	if witnessScript != nil {
+		return witnessScript
+	}
+	if _, _, err := multisigStats(redeemScript); err == nil {
+		return redeemScript
+	}
+	return nil
+}
+
+// requiredSigs returns M of the M-of-N script.
+func requiredSigs(script []byte) (int, error) {
+	_, required, err := multisigStats(script)
+	return required, err
+}
+
+// multisigStats returns N and M of the M-of-N script. Unlike txscript it takes the witness scripts of over 16 keys,
+// which push N as a number instead of OP_16.
+func multisigStats(script []byte) (pubKeys int, required int, err error) {
+	var ops []byte
+	var pushes [][]byte
+	tokenizer := txscript.MakeScriptTokenizer(0, script)
+	for tokenizer.Next() {
+		ops = append(ops, tokenizer.Opcode())
+		pushes = append(pushes, tokenizer.Data())
+	}
+	if tokenizer.Err() != nil {
+		return 0, 0, tokenizer.Err()
+	}
+	n := len(ops)
+	if n < 4 || ops[n-1] != txscript.OP_CHECKMULTISIG {
+		return 0, 0, fmt.Errorf("script isn't a multisig")
+	}
+	required, pubKeys = smallScriptNum(ops[0], pushes[0]), smallScriptNum(ops[n-2], pushes[n-2])
+	if pubKeys != n-3 || required < 1 || required > pubKeys {
+		return 0, 0, fmt.Errorf("script isn't a multisig")
+	}
+	for _, key := range pushes[1 : n-2] {
+		if len(key) != btcec.PubKeyBytesLenCompressed {
+			return 0, 0, fmt.Errorf("script isn't a multisig")
+		}
+	}
+	return pubKeys, required, nil
+}
+
+// smallScriptNum returns the number from 1 to 127 the opcode pushes, -1 for anything else.
+func smallScriptNum(op byte, data []byte) int {
+	switch {
+	case op >= txscript.OP_1 && op <= txscript.OP_16:
+		return int(op-txscript.OP_1) + 1
+	case op == txscript.OP_DATA_1 && data[0] > 16 && data[0] < 0x80:
+		return int(data[0])
+	default:
+		return -1
+	}
+}
+
+func isCosigner(script []byte, signer Signer) bool {
+	pushes, err := txscript.PushedData(script)
+	if err != nil {
+		return false
+	}
+	pubKey := signer.PublicKey().SerializeCompressed()
+	for _, p := range pushes {
+		if bytes.Equal(p, pubKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkCosigners ensures Create has the keys of enough co-signers to spend from the multisig.
+func checkCosigners(info privateKeyInfo) error {
+	required, err := requiredSigs(info.multisigScript())
+	if err != nil {
+		return err
+	}
+	if len(info.cosigners) < required {
+		return fmt.Errorf("multisig requires the private keys of %d co-signers, got %d, use CreatePSBT to let them sign separately", required, len(info.cosigners))
+	}
+	return nil
+}
+
+// signMultisigInput signs the input with the keys of the required number of co-signers.
+func signMultisigInput(tx *wire.MsgTx, sigHashes *txscript.TxSigHashes, idx int, utxo utxoWithKey) error {
+	info := utxo.pkInfo
+	script := info.multisigScript()
+	required, err := requiredSigs(script)
+	if err != nil {
+		return err
+	}
+	var sigs [][]byte
+	for _, key := range info.cosigners[:required] {
+		var sig []byte
+		if info.witnessScript == nil {
+			sig, err = rawTxInSignature(tx, idx, script, key)
+		} else {
+			sig, err = rawTxInWitnessSignature(tx, sigHashes, idx, utxo.Balance, script, key)
+		}
+		if err != nil {
+			return err
+		}
+		sigs = append(sigs, sig)
+	}
+	in := tx.TxIn[idx]
+	in.SignatureScript, in.Witness, err = multisigUnlockingScripts(info.redeemScript, info.witnessScript, sigs)
+	return err
+}
+
+// multisigUnlockingScripts returns the signature script and the witness spending the multisig with the signatures
+// in the order of their public keys. The dummy element goes first for the extra item OP_CHECKMULTISIG pops.
+func multisigUnlockingScripts(redeemScript, witnessScript []byte, sigs [][]byte) ([]byte, wire.TxWitness, error) {
+	if witnessScript == nil {
+		builder := txscript.NewScriptBuilder().AddOp(txscript.OP_0)
+		for _, sig := range sigs {
+			builder.AddData(sig)
+		}
+		sigScript, err := builder.AddData(redeemScript).Script()
+		return sigScript, nil, err
+	}
+
+	witness := append(append(wire.TxWitness{nil}, sigs...), witnessScript)
+	if redeemScript == nil {
+		return nil, witness, nil
+	}
+	// nested SegWit reveals the witness program in the signature script
+	sigScript, err := txscript.NewScriptBuilder().AddData(redeemScript).Script()
+	return sigScript, witness, err
+}
+
+// multisigInputWeight returns the weight of the input signed by the required number of co-signers
+// and whether it has the witness.
+func multisigInputWeight(info privateKeyInfo) (int, bool) {
+	script := info.multisigScript()
+	required, _ := requiredSigs(script)
+	sigsSize := required * (1 + maxECDSASigSize)
+	if info.witnessScript == nil {
+		// the dummy OP_0, the signatures and the push of the redeem script
+		sigScriptSize := 1 + sigsSize + pushSize(len(script)) + len(script)
+		return inputBaseWeight + (wire.VarIntSerializeSize(uint64(sigScriptSize))-1+sigScriptSize)*4, false
+	}
+	// the number of items, the empty dummy, the signatures and the witness script
+	weight := inputBaseWeight + wire.VarIntSerializeSize(uint64(required+2)) + 1 + sigsSize +
+		wire.VarIntSerializeSize(uint64(len(script))) + len(script)
+	if info.redeemScript != nil {
+		weight += (1 + len(info.redeemScript)) * 4
+	}
+	return weight, true
+}
+
+// pushSize is the size of the opcode pushing the data of the length onto the stack.
+func pushSize(length int) int {
+	switch {
+	case length < txscript.OP_PUSHDATA1:
+		return 1
+	case length <= 0xff:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// signMultisigPSBTInput adds the signatures of the co-signers among the keys to the multisig input,
+// returns how many it added.
+func signMultisigPSBTInput(updater *psbt.Updater, sigHashes *txscript.TxSigHashes, idx int, prevOut *wire.TxOut, keys []Signer) (int, error) {
+	p := updater.Upsbt
+	pInput := p.Inputs[idx]
+	script := multisigScriptOf(pInput.RedeemScript, pInput.WitnessScript)
+	var signed int
+	for _, key := range keys {
+		if !isCosigner(script, key) {
+			continue
+		}
+		var sig []byte
+		var err error
+		if pInput.WitnessScript != nil {
+			sig, err = rawTxInWitnessSignature(p.UnsignedTx, sigHashes, idx, prevOut.Value, script, key)
+		} else {
+			sig, err = rawTxInSignature(p.UnsignedTx, idx, script, key)
+		}
+		if err != nil {
+			return 0, err
+		}
+		err = addPartialSig(updater, idx, sig, key.PublicKey().SerializeCompressed(), nil)
+		if err != nil {
+			return 0, err
+		}
+		signed++
+	}
+	return signed, nil
+}
+
+// finalizeMultisigPSBTInputs builds the final scripts of the multisig inputs with enough signatures,
+// psbt doesn't take the witness scripts of over 16 keys. The extra signatures are dropped, OP_CHECKMULTISIG doesn't accept them.
+func finalizeMultisigPSBTInputs(p *psbt.Packet) error {
+	for i := range p.Inputs {
+		pInput := &p.Inputs[i]
+		script := multisigScriptOf(pInput.RedeemScript, pInput.WitnessScript)
+		if script == nil || pInput.FinalScriptSig != nil || pInput.FinalScriptWitness != nil {
+			continue
+		}
+		required, err := requiredSigs(script)
+		if err != nil {
+			return err
+		}
+		pushes, err := txscript.PushedData(script)
+		if err != nil {
+			return err
+		}
+		var sigs [][]byte
+		for _, pubKey := range pushes {
+			for _, sig := range pInput.PartialSigs {
+				if len(sigs) < required && bytes.Equal(sig.PubKey, pubKey) {
+					sigs = append(sigs, sig.Signature)
+				}
+			}
+		}
+		if len(sigs) < required {
+			return fmt.Errorf("input %d has %d of %d signatures of the multisig", i, len(sigs), required)
+		}
+
+		sigScript, witness, err := multisigUnlockingScripts(pInput.RedeemScript, pInput.WitnessScript, sigs)
+		if err != nil {
+			return err
+		}
+		// like psbt, only the UTXO and the final scripts stay in the finalized input
+		final := psbt.NewPsbtInput(pInput.NonWitnessUtxo, pInput.WitnessUtxo)
+		final.FinalScriptSig = sigScript
+		if witness != nil {
+			var buf bytes.Buffer
+			if err := psbt.WriteTxWitness(&buf, witness); err != nil {
+				return err
+			}
+			final.FinalScriptWitness = buf.Bytes()
+		}
+		*pInput = *final
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package txutil
+
+import (
+	"encoding/hex"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/glossd/btc/addressinfo"
+	"github.com/glossd/btc/netchain"
+	"github.com/glossd/btc/wallet"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+var multisigTypes = []wallet.AddressType{wallet.P2SH, wallet.P2WSH, wallet.P2SHP2WSH}
+
+func multisigOf(t *testing.T, required int, privateKeys ...string) *Multisig {
+	var pubKeys []string
+	for _, key := range privateKeys {
+		pubKeys = append(pubKeys, publicKeyOf(t, key))
+	}
+	return &Multisig{Required: required, PublicKeys: pubKeys}
+}
+
+func TestCreate_Multisig(t *testing.T) {
+	multisig := multisigOf(t, 2, privateKey1, privateKey2, privateKey3)
+	for _, addrType := range multisigTypes {
+		t.Run(addrType.String(), func(t *testing.T) {
+			addr, err := wallet.MultisigAddress(multisig.Required, multisig.PublicKeys, netchain.TestNet, addrType)
+			assert.Nil(t, err)
+			params := CreateParams{
+				Multisig:    multisig,
+				PrivateKeys: []string{privateKey3, privateKey1},
+				AddressType: addrType,
+				Destination: destination2,
+				Amount:      5e5,
+				FeeRate:     10,
+				Fetch:       addressinfo.FetchMock,
+				Net:         netchain.TestNet,
+			}
+			result, err := CreateWithResult(params)
+			assert.Nil(t, err)
+			tx := decodeTx(t, result.RawTx)
+			verifyInput(t, tx, 0, addressPkScript(t, addr), addressinfo.MockAddressBalance)
+			// the change goes back to the multisig
+			outputTo(t, tx, addressPkScript(t, addr))
+			// the estimated size is never below the signed one
+			assert.GreaterOrEqual(t, result.Fee, 10*virtualSize(tx))
+			assert.Less(t, result.Fee, 11*virtualSize(tx))
+
+			params.PrivateKeys = []string{privateKey1}
+			_, err = Create(params)
+			assert.NotNil(t, err, "one signature of two")
+		})
+	}
+}
+
+func TestCreate_MultisigMaxKeys(t *testing.T) {
+	// the witness scripts take 20 keys, N over 16 is pushed as a number
+	multisig := multisigOf(t, 2, privateKey1, privateKey2)
+	for len(multisig.PublicKeys) < 20 {
+		priv, err := btcec.NewPrivateKey()
+		assert.Nil(t, err)
+		multisig.PublicKeys = append(multisig.PublicKeys, hex.EncodeToString(priv.PubKey().SerializeCompressed()))
+	}
+	for _, addrType := range []wallet.AddressType{wallet.P2WSH, wallet.P2SHP2WSH} {
+		t.Run(addrType.String(), func(t *testing.T) {
+			addr, err := wallet.MultisigAddress(multisig.Required, multisig.PublicKeys, netchain.TestNet, addrType)
+			assert.Nil(t, err)
+			params := CreateParams{
+				Multisig:    multisig,
+				PrivateKeys: []string{privateKey1, privateKey2},
+				AddressType: addrType,
+				Destination: destination2,
+				Amount:      5e5,
+				FeeRate:     10,
+				Fetch:       addressinfo.FetchMock,
+				Net:         netchain.TestNet,
+			}
+			result, err := CreateWithResult(params)
+			assert.Nil(t, err)
+			tx := decodeTx(t, result.RawTx)
+			verifyInput(t, tx, 0, addressPkScript(t, addr), addressinfo.MockAddressBalance)
+			assert.GreaterOrEqual(t, result.Fee, 10*virtualSize(tx))
+
+			params.PrivateKeys = nil
+			packet, err := CreatePSBT(params)
+			assert.Nil(t, err)
+			signed, err := SignPSBT(packet, []string{privateKey1, privateKey2}, netchain.TestNet)
+			assert.Nil(t, err)
+			tx = finalizeAndExtract(t, signed)
+			verifyInput(t, tx, 0, addressPkScript(t, addr), addressinfo.MockAddressBalance)
+		})
+	}
+}
+
+func TestNewSelectionParams_Multisig(t *testing.T) {
+	for _, addrType := range multisigTypes {
+		t.Run(addrType.String(), func(t *testing.T) {
+			params, err := checkCreateParams(CreateParams{
+				Multisig:    multisigOf(t, 2, privateKey1, privateKey2, privateKey3),
+				PrivateKeys: []string{privateKey1, privateKey2},
+				AddressType: addrType,
+				Destination: destination2,
+				Amount:      5e5,
+				FeeRate:     10,
+				Fetch:       addressinfo.FetchMock,
+				Net:         netchain.TestNet,
+			})
+			assert.Nil(t, err)
+			coins, err := fetchCoins(params)
+			assert.Nil(t, err)
+
+			// the change goes back to the multisig, spending it costs as much as spending its coins
+			p := newSelectionParams(params, coins)
+			assert.EqualValues(t, p.ChangeFee+coins[0].LongTermFee, p.CostOfChange)
+		})
+	}
+}
+
+func TestCreate_MultisigValidation(t *testing.T) {
+	params := CreateParams{
+		Multisig:    multisigOf(t, 2, privateKey1, privateKey2),
+		PrivateKeys: []string{privateKey1, privateKey3},
+		AddressType: wallet.P2WSH,
+		Destination: destination2,
+		Amount:      5e5,
+		Fetch:       addressinfo.FetchMock,
+		Net:         netchain.TestNet,
+	}
+	_, err := Create(params)
+	assert.NotNil(t, err, "not a co-signer")
+
+	params.PrivateKeys = []string{privateKey1, privateKey1}
+	_, err = Create(params)
+	assert.NotNil(t, err, "same key twice")
+
+	params.PrivateKeys = []string{privateKey1, privateKey2}
+	params.AddressType = wallet.P2WPKH
+	_, err = Create(params)
+	assert.NotNil(t, err, "not a multisig address type")
+}
+
+func TestPSBT_Multisig(t *testing.T) {
+	multisig := multisigOf(t, 2, privateKey1, privateKey2, privateKey3)
+	for _, addrType := range multisigTypes {
+		t.Run(addrType.String(), func(t *testing.T) {
+			addr, err := wallet.MultisigAddress(multisig.Required, multisig.PublicKeys, netchain.TestNet, addrType)
+			assert.Nil(t, err)
+			prevTx := mockPrevTx(t, addr)
+			packet, err := CreatePSBT(CreateParams{
+				Multisig:    multisig,
+				AddressType: addrType,
+				Destination: destination1,
+				Amount:      5e5,
+				Fetch: func(address string, net netchain.Net) (addressinfo.Address, error) {
+					return addressinfo.Address{Balance: addressinfo.MockAddressBalance, UTXOs: []addressinfo.UTXO{{
+						TxID:     prevTx.TxHash().String(),
+						Pbscript: hex.EncodeToString(prevTx.TxOut[1].PkScript),
+						Balance:  prevTx.TxOut[1].Value,
+						TxOutIdx: 1,
+					}}}, nil
+				},
+				FetchRawTx: func(txID string, net netchain.Net) (string, error) {
+					return hexEncodeTx(prevTx)
+				},
+				Net: netchain.TestNet,
+			})
+			assert.Nil(t, err)
+
+			// the co-signers sign one after another
+			signed, err := SignPSBT(packet, []string{privateKey2}, netchain.TestNet)
+			assert.Nil(t, err)
+			_, err = FinalizePSBT(signed)
+			assert.NotNil(t, err, "one signature of two")
+			signed, err = SignPSBT(signed, []string{privateKey3}, netchain.TestNet)
+			assert.Nil(t, err)
+			tx := finalizeAndExtract(t, signed)
+			verifyInput(t, tx, 0, addressPkScript(t, addr), addressinfo.MockAddressBalance)
+
+			// or separately and combine the signatures, the extra one is dropped
+			signed1, err := SignPSBT(packet, []string{privateKey1}, netchain.TestNet)
+			assert.Nil(t, err)
+			signed23, err := SignPSBT(packet, []string{privateKey2, privateKey3}, netchain.TestNet)
+			assert.Nil(t, err)
+			combined, err := CombinePSBT(signed1, signed23)
+			assert.Nil(t, err)
+			tx = finalizeAndExtract(t, combined)
+			verifyInput(t, tx, 0, addressPkScript(t, addr), addressinfo.MockAddressBalance)
+		})
+	}
+}
@@ -0,0 +1,396 @@
+package txutil
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/glossd/btc/addressinfo"
+	"github.com/glossd/btc/netchain"
+	"github.com/glossd/btc/wallet"
+	"strings"
+)
+
+// address types SignPSBT and BumpFee look for the inputs of a private key
+var signableAddressTypes = []wallet.AddressType{wallet.P2PKH, wallet.P2WPKH, wallet.P2SHP2WPKH, wallet.P2TR}
+
+// CreatePSBT works as Create but returns the unsigned transaction as base64-encoded BIP174 PSBT.
+// The private keys aren't needed, specify PublicKeys or Addresses to spend from.
+func CreatePSBT(params CreateParams) (string, error) {
+	params, err := checkCreateParams(params)
+	if err != nil {
+		return "", err
+	}
+
+	params, addrs, err := selectAddresses(params)
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := buildUnsignedTx(params, addrs)
+	if err != nil {
+		return "", err
+	}
+	err = shuffleOutputs(tx)
+	if err != nil {
+		return "", err
+	}
+
+	packet, err := psbt.NewFromUnsignedTx(tx)
+	if err != nil {
+		return "", err
+	}
+
+	utxosOfIns, err := utxosOfInputs(tx, addrs)
+	if err != nil {
+		return "", err
+	}
+	for i, utxo := range utxosOfIns {
+		pInput := &packet.Inputs[i]
+		if isWitnessUTXO(utxo) {
+			pInput.WitnessUtxo = wire.NewTxOut(utxo.Balance, utxo.pkScript)
+		} else {
+			// legacy signatures don't commit to the amount, the signer must see the whole previous transaction
+			prevTx, err := fetchPrevTx(tx.TxIn[i].PreviousOutPoint, params.FetchRawTx, params.Net)
+			if err != nil {
+				return "", err
+			}
+			pInput.NonWitnessUtxo = prevTx
+		}
+		pInput.RedeemScript = utxo.pkInfo.redeemScript
+		pInput.WitnessScript = utxo.pkInfo.witnessScript
+		if txscript.IsPayToTaproot(utxo.pkScript) && utxo.pkInfo.pubKey != nil {
+			pInput.TaprootInternalKey = schnorr.SerializePubKey(utxo.pkInfo.pubKey)
+		}
+	}
+
+	return packet.B64Encode()
+}
+
+// isWitnessUTXO tells whether the UTXO is spent with the witness.
+// P2SH addresses without the known redeem script are assumed to be P2SH-P2WPKH.
+func isWitnessUTXO(utxo utxoWithKey) bool {
+	if txscript.IsPayToScriptHash(utxo.pkScript) {
+		return utxo.pkInfo.redeemScript == nil || txscript.IsWitnessProgram(utxo.pkInfo.redeemScript)
+	}
+	return txscript.IsWitnessProgram(utxo.pkScript)
+}
+
+func fetchPrevTx(outPoint wire.OutPoint, fetchRawTx addressinfo.FetchRawTx, net netchain.Net) (*wire.MsgTx, error) {
+	rawTx, err := fetchRawTx(outPoint.Hash.String(), net)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't fetch previous transaction %s: %w", outPoint.Hash, err)
+	}
+	prevTx, err := hexDecodeTx(rawTx)
+	if err != nil {
+		return nil, err
+	}
+	if prevTx.TxHash() != outPoint.Hash {
+		return nil, fmt.Errorf("fetched transaction %s doesn't match the input %s", prevTx.TxHash(), outPoint)
+	}
+	if int(outPoint.Index) >= len(prevTx.TxOut) {
+		return nil, fmt.Errorf("previous transaction %s doesn't have output %d", outPoint.Hash, outPoint.Index)
+	}
+	return prevTx, nil
+}
+
+// SignPSBT adds the signatures of the private keys to the inputs they can spend.
+// Each private key can sign the inputs of any of its address types and the multisig inputs it's a co-signer of.
+// The net defaults to netchain.MainNet.
+func SignPSBT(packet string, privateKeys []string, net netchain.Net) (string, error) {
+	if net == "" {
+		net = netchain.MainNet
+	}
+	if err := checkNet(net); err != nil {
+		return "", err
+	}
+	p, err := decodePSBT(packet)
+	if err != nil {
+		return "", err
+	}
+
+	keys, err := toPkInfosOfAllTypes(privateKeys, net)
+	if err != nil {
+		return "", err
+	}
+	var signers []Signer
+	for _, key := range privateKeys {
+		signer, err := NewWIFSigner(key)
+		if err != nil {
+			return "", err
+		}
+		signers = append(signers, signer)
+	}
+
+	fetcher, err := psbtPrevOutFetcher(p)
+	if err != nil {
+		return "", err
+	}
+	tx := p.UnsignedTx
+	sigHashes := txscript.NewTxSigHashes(tx, fetcher)
+	updater, err := psbt.NewUpdater(p)
+	if err != nil {
+		return "", err
+	}
+
+	var signed int
+	for i, in := range tx.TxIn {
+		prevOut := fetcher.FetchPrevOutput(in.PreviousOutPoint)
+		if multisigScriptOf(p.Inputs[i].RedeemScript, p.Inputs[i].WitnessScript) != nil {
+			n, err := signMultisigPSBTInput(updater, sigHashes, i, prevOut, signers)
+			if err != nil {
+				return "", err
+			}
+			signed += n
+			continue
+		}
+		info, ok := findKeyOfScript(keys, prevOut.PkScript)
+		if !ok {
+			continue
+		}
+		signer := info.signer
+		switch {
+		case txscript.IsPayToTaproot(prevOut.PkScript):
+			sig, err := rawTxInTaprootSignature(tx, sigHashes, i, prevOut.Value, prevOut.PkScript, signer)
+			if err != nil {
+				return "", err
+			}
+			p.Inputs[i].TaprootKeySpendSig = sig
+			p.Inputs[i].TaprootInternalKey = schnorr.SerializePubKey(info.pubKey)
+		case txscript.IsPayToWitnessPubKeyHash(prevOut.PkScript):
+			sig, err := rawTxInWitnessSignature(tx, sigHashes, i, prevOut.Value, prevOut.PkScript, signer)
+			if err != nil {
+				return "", err
+			}
+			err = addPartialSig(updater, i, sig, info.pubKey.SerializeCompressed(), nil)
+			if err != nil {
+				return "", err
+			}
+		case txscript.IsPayToScriptHash(prevOut.PkScript):
+			sig, err := rawTxInWitnessSignature(tx, sigHashes, i, prevOut.Value, info.redeemScript, signer)
+			if err != nil {
+				return "", err
+			}
+			err = addPartialSig(updater, i, sig, info.pubKey.SerializeCompressed(), info.redeemScript)
+			if err != nil {
+				return "", err
+			}
+		default:
+			sig, err := rawTxInSignature(tx, i, prevOut.PkScript, signer)
+			if err != nil {
+				return "", err
+			}
+			err = addPartialSig(updater, i, sig, info.serializedPubKey(), nil)
+			if err != nil {
+				return "", err
+			}
+		}
+		signed++
+	}
+	if signed == 0 {
+		return "", fmt.Errorf("none of the inputs can be signed with the private keys")
+	}
+
+	return p.B64Encode()
+}
+
+func addPartialSig(updater *psbt.Updater, inIndex int, sig, pubKey, redeemScript []byte) error {
+	_, err := updater.Sign(inIndex, sig, pubKey, redeemScript, nil)
+	if errors.Is(err, psbt.ErrDuplicateKey) {
+		// already signed by this key
+		return nil
+	}
+	return err
+}
+
+// toPkInfosOfAllTypes returns the info of every address type of each key.
+func toPkInfosOfAllTypes(privateKeys []string, net netchain.Net) ([]privateKeyInfo, error) {
+	var keys []privateKeyInfo
+	for _, key := range privateKeys {
+		for _, addrType := range signableAddressTypes {
+			info, err := toPkInfo(key, addrType, net)
+			if err != nil {
+				return nil, fmt.Errorf("one of the private keys is malformed: %w", err)
+			}
+			keys = append(keys, info)
+		}
+	}
+	return keys, nil
+}
+
+func findKeyOfScript(keys []privateKeyInfo, pkScript []byte) (privateKeyInfo, bool) {
+	for _, k := range keys {
+		if bytes.Equal(k.pkScript, pkScript) {
+			return k, true
+		}
+	}
+	return privateKeyInfo{}, false
+}
+
+func psbtPrevOutFetcher(p *psbt.Packet) (*txscript.MultiPrevOutFetcher, error) {
+	fetcher := txscript.NewMultiPrevOutFetcher(nil)
+	for i, in := range p.UnsignedTx.TxIn {
+		pInput := p.Inputs[i]
+		switch {
+		case pInput.WitnessUtxo != nil:
+			fetcher.AddPrevOut(in.PreviousOutPoint, pInput.WitnessUtxo)
+		case pInput.NonWitnessUtxo != nil:
+			prevTx, outPoint := pInput.NonWitnessUtxo, in.PreviousOutPoint
+			if prevTx.TxHash() != outPoint.Hash {
+				return nil, fmt.Errorf("previous transaction %s of input %d of PSBT doesn't match %s", prevTx.TxHash(), i, outPoint)
+			}
+			if int(outPoint.Index) >= len(prevTx.TxOut) {
+				return nil, fmt.Errorf("previous transaction %s of input %d of PSBT doesn't have output %d", outPoint.Hash, i, outPoint.Index)
+			}
+			fetcher.AddPrevOut(outPoint, prevTx.TxOut[outPoint.Index])
+		default:
+			return nil, fmt.Errorf("input %d of PSBT doesn't have its UTXO", i)
+		}
+	}
+	return fetcher, nil
+}
+
+// CombinePSBT merges the signatures and scripts of the PSBTs of the same transaction,
+// e.g. signed by different co-signers.
+func CombinePSBT(packets ...string) (string, error) {
+	if len(packets) == 0 {
+		return "", fmt.Errorf("no PSBT to combine")
+	}
+	result, err := decodePSBT(packets[0])
+	if err != nil {
+		return "", err
+	}
+	for _, packet := range packets[1:] {
+		other, err := decodePSBT(packet)
+		if err != nil {
+			return "", err
+		}
+		if other.UnsignedTx.TxHash() != result.UnsignedTx.TxHash() {
+			return "", fmt.Errorf("PSBTs are of different transactions, %s and %s", result.UnsignedTx.TxHash(), other.UnsignedTx.TxHash())
+		}
+		for i := range result.Inputs {
+			combinePInput(&result.Inputs[i], other.Inputs[i])
+		}
+		for i := range result.Outputs {
+			combinePOutput(&result.Outputs[i], other.Outputs[i])
+		}
+	}
+	return result.B64Encode()
+}
+
+func combinePInput(in *psbt.PInput, other psbt.PInput) {
+	if in.NonWitnessUtxo == nil {
+		in.NonWitnessUtxo = other.NonWitnessUtxo
+	}
+	if in.WitnessUtxo == nil {
+		in.WitnessUtxo = other.WitnessUtxo
+	}
+	if in.SighashType == 0 {
+		in.SighashType = other.SighashType
+	}
+	if in.RedeemScript == nil {
+		in.RedeemScript = other.RedeemScript
+	}
+	if in.WitnessScript == nil {
+		in.WitnessScript = other.WitnessScript
+	}
+	if in.FinalScriptSig == nil {
+		in.FinalScriptSig = other.FinalScriptSig
+	}
+	if in.FinalScriptWitness == nil {
+		in.FinalScriptWitness = other.FinalScriptWitness
+	}
+	if in.TaprootKeySpendSig == nil {
+		in.TaprootKeySpendSig = other.TaprootKeySpendSig
+	}
+	if in.TaprootInternalKey == nil {
+		in.TaprootInternalKey = other.TaprootInternalKey
+	}
+	for _, sig := range other.PartialSigs {
+		if !hasPartialSig(in.PartialSigs, sig.PubKey) {
+			in.PartialSigs = append(in.PartialSigs, sig)
+		}
+	}
+	for _, d := range other.Bip32Derivation {
+		if !hasBip32Derivation(in.Bip32Derivation, d.PubKey) {
+			in.Bip32Derivation = append(in.Bip32Derivation, d)
+		}
+	}
+}
+
+func combinePOutput(out *psbt.POutput, other psbt.POutput) {
+	if out.RedeemScript == nil {
+		out.RedeemScript = other.RedeemScript
+	}
+	if out.WitnessScript == nil {
+		out.WitnessScript = other.WitnessScript
+	}
+	if out.TaprootInternalKey == nil {
+		out.TaprootInternalKey = other.TaprootInternalKey
+	}
+	for _, d := range other.Bip32Derivation {
+		if !hasBip32Derivation(out.Bip32Derivation, d.PubKey) {
+			out.Bip32Derivation = append(out.Bip32Derivation, d)
+		}
+	}
+}
+
+func hasPartialSig(sigs []*psbt.PartialSig, pubKey []byte) bool {
+	for _, s := range sigs {
+		if bytes.Equal(s.PubKey, pubKey) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasBip32Derivation(derivations []*psbt.Bip32Derivation, pubKey []byte) bool {
+	for _, d := range derivations {
+		if bytes.Equal(d.PubKey, pubKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// FinalizePSBT builds the final scripts and witnesses of the inputs from their signatures.
+// Fails if any of the inputs isn't fully signed.
+func FinalizePSBT(packet string) (string, error) {
+	p, err := decodePSBT(packet)
+	if err != nil {
+		return "", err
+	}
+	err = finalizeMultisigPSBTInputs(p)
+	if err != nil {
+		return "", fmt.Errorf("couldn't finalize PSBT: %w", err)
+	}
+	err = psbt.MaybeFinalizeAll(p)
+	if err != nil {
+		return "", fmt.Errorf("couldn't finalize PSBT: %w", err)
+	}
+	return p.B64Encode()
+}
+
+// ExtractTx returns the hex-encoded signed transaction of the finalized PSBT, ready to be broadcasted.
+func ExtractTx(packet string) (string, error) {
+	p, err := decodePSBT(packet)
+	if err != nil {
+		return "", err
+	}
+	tx, err := psbt.Extract(p)
+	if err != nil {
+		return "", fmt.Errorf("couldn't extract transaction from PSBT: %w", err)
+	}
+	return hexEncodeTx(tx)
+}
+
+func decodePSBT(packet string) (*psbt.Packet, error) {
+	p, err := psbt.NewFromRawBytes(strings.NewReader(packet), true)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't decode PSBT: %w", err)
+	}
+	return p, nil
+}
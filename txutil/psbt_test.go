@@ -0,0 +1,178 @@
+package txutil
+
+import (
+	"encoding/hex"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/glossd/btc/addressinfo"
+	"github.com/glossd/btc/netchain"
+	"github.com/glossd/btc/wallet"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestPSBT_FromPublicKey(t *testing.T) {
+	for _, addrType := range []wallet.AddressType{wallet.P2WPKH, wallet.P2SHP2WPKH, wallet.P2TR} {
+		t.Run(addrType.String(), func(t *testing.T) {
+			packet, err := CreatePSBT(CreateParams{
+				PublicKeys:  []string{publicKeyOf(t, privateKey2)},
+				AddressType: addrType,
+				Destination: destination1,
+				Amount:      5e5,
+				Fetch:       addressinfo.FetchMock,
+				Net:         netchain.TestNet,
+			})
+			assert.Nil(t, err)
+
+			signed, err := SignPSBT(packet, []string{privateKey2}, netchain.TestNet)
+			assert.Nil(t, err)
+			tx := finalizeAndExtract(t, signed)
+
+			addr, err := wallet.AddressFromPrivateKeyWithType(privateKey2, netchain.TestNet, addrType)
+			assert.Nil(t, err)
+			verifyInput(t, tx, 0, addressPkScript(t, addr), addressinfo.MockAddressBalance)
+		})
+	}
+}
+
+func TestPSBT_FromAddress(t *testing.T) {
+	t.Run("Legacy", func(t *testing.T) {
+		packet := legacyPSBT(t)
+
+		signed, err := SignPSBT(packet, []string{privateKey1}, netchain.TestNet)
+		assert.Nil(t, err)
+		tx := finalizeAndExtract(t, signed)
+		verifyInput(t, tx, 0, addressPkScript(t, destination1), addressinfo.MockAddressBalance)
+	})
+	t.Run("P2SH-P2WPKH", func(t *testing.T) {
+		addr, err := wallet.AddressFromPrivateKeyWithType(privateKey2, netchain.TestNet, wallet.P2SHP2WPKH)
+		assert.Nil(t, err)
+		packet, err := CreatePSBT(CreateParams{
+			Addresses:   []string{addr},
+			Destination: destination1,
+			Amount:      5e5,
+			Fetch:       addressinfo.FetchMock,
+			Net:         netchain.TestNet,
+		})
+		assert.Nil(t, err)
+
+		// the redeem script is unknown until the signer reveals it
+		signed, err := SignPSBT(packet, []string{privateKey2}, netchain.TestNet)
+		assert.Nil(t, err)
+		tx := finalizeAndExtract(t, signed)
+		verifyInput(t, tx, 0, addressPkScript(t, addr), addressinfo.MockAddressBalance)
+	})
+}
+
+func TestCombinePSBT(t *testing.T) {
+	addr1, err := wallet.AddressFromPrivateKeyWithType(privateKey1, netchain.TestNet, wallet.P2WPKH)
+	assert.Nil(t, err)
+	addr2, err := wallet.AddressFromPrivateKeyWithType(privateKey2, netchain.TestNet, wallet.P2WPKH)
+	assert.Nil(t, err)
+	packet, err := CreatePSBT(CreateParams{
+		Addresses:   []string{addr1, addr2},
+		Destination: destination3,
+		Amount:      addressinfo.MockAddressBalance * 3 / 2,
+		Fetch:       addressinfo.FetchMock,
+		Net:         netchain.TestNet,
+	})
+	assert.Nil(t, err)
+
+	signed1, err := SignPSBT(packet, []string{privateKey1}, netchain.TestNet)
+	assert.Nil(t, err)
+	signed2, err := SignPSBT(packet, []string{privateKey2}, netchain.TestNet)
+	assert.Nil(t, err)
+	_, err = FinalizePSBT(signed1)
+	assert.NotNil(t, err, "one signature of two")
+
+	combined, err := CombinePSBT(signed1, signed2)
+	assert.Nil(t, err)
+	tx := finalizeAndExtract(t, combined)
+	assert.EqualValues(t, 2, len(tx.TxIn))
+	verifyInput(t, tx, 0, addressPkScript(t, addr1), addressinfo.MockAddressBalance)
+	verifyInput(t, tx, 1, addressPkScript(t, addr2), addressinfo.MockAddressBalance)
+
+	_, err = SignPSBT(packet, []string{privateKey3}, netchain.TestNet)
+	assert.NotNil(t, err, "key of neither input")
+}
+
+func TestSignPSBT_Validation(t *testing.T) {
+	packet := legacyPSBT(t)
+	_, err := SignPSBT(packet, []string{privateKey1}, "unknown")
+	assert.NotNil(t, err)
+
+	p, err := decodePSBT(packet)
+	assert.Nil(t, err)
+	p.UnsignedTx.TxIn[0].PreviousOutPoint.Index = 2
+	outOfRange, err := p.B64Encode()
+	assert.Nil(t, err)
+	_, err = SignPSBT(outOfRange, []string{privateKey1}, netchain.TestNet)
+	assert.NotNil(t, err, "output index of the previous transaction out of range")
+
+	p, err = decodePSBT(packet)
+	assert.Nil(t, err)
+	p.Inputs[0].NonWitnessUtxo = mockPrevTx(t, destination2)
+	otherTx, err := p.B64Encode()
+	assert.Nil(t, err)
+	_, err = SignPSBT(otherTx, []string{privateKey1}, netchain.TestNet)
+	assert.NotNil(t, err, "previous transaction of another input")
+}
+
+func TestCreate_PublicKeys(t *testing.T) {
+	_, err := Create(CreateParams{
+		PublicKeys:  []string{publicKeyOf(t, privateKey2)},
+		Destination: destination1,
+		Amount:      5e5,
+		Fetch:       addressinfo.FetchMock,
+		Net:         netchain.TestNet,
+	})
+	assert.NotNil(t, err, "Create can't sign without private keys")
+}
+
+func finalizeAndExtract(t *testing.T, packet string) *wire.MsgTx {
+	finalized, err := FinalizePSBT(packet)
+	assert.Nil(t, err)
+	rawTx, err := ExtractTx(finalized)
+	assert.Nil(t, err)
+	return decodeTx(t, rawTx)
+}
+
+func publicKeyOf(t *testing.T, privateKey string) string {
+	wif, err := btcutil.DecodeWIF(privateKey)
+	assert.Nil(t, err)
+	return hex.EncodeToString(wif.PrivKey.PubKey().SerializeCompressed())
+}
+
+// mockPrevTx returns a transaction paying MockAddressBalance to the address in its second output.
+// legacyPSBT spends the P2PKH output of destination1, the PSBT carries the whole previous transaction.
+func legacyPSBT(t *testing.T) string {
+	prevTx := mockPrevTx(t, destination1)
+	packet, err := CreatePSBT(CreateParams{
+		Addresses:   []string{destination1},
+		Destination: destination2,
+		Amount:      5e5,
+		Fetch: func(address string, net netchain.Net) (addressinfo.Address, error) {
+			return addressinfo.Address{Balance: addressinfo.MockAddressBalance, UTXOs: []addressinfo.UTXO{{
+				TxID:     prevTx.TxHash().String(),
+				Pbscript: hex.EncodeToString(prevTx.TxOut[1].PkScript),
+				Balance:  prevTx.TxOut[1].Value,
+				TxOutIdx: 1,
+			}}}, nil
+		},
+		FetchRawTx: func(txID string, net netchain.Net) (string, error) {
+			return hexEncodeTx(prevTx)
+		},
+		Net: netchain.TestNet,
+	})
+	assert.Nil(t, err)
+	return packet
+}
+
+func mockPrevTx(t *testing.T, address string) *wire.MsgTx {
+	tx := wire.NewMsgTx(wire.TxVersion)
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{}, 0), nil, nil))
+	tx.AddTxOut(wire.NewTxOut(1000, addressPkScript(t, destination3)))
+	tx.AddTxOut(wire.NewTxOut(addressinfo.MockAddressBalance, addressPkScript(t, address)))
+	return tx
+}
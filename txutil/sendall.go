@@ -0,0 +1,45 @@
+package txutil
+
+import "fmt"
+
+// sendAllAmounts splits what's left after the fee among the destinations by their Share.
+// The shares are rounded down and the first destination gets the remainder, so the split is deterministic.
+// A single destination without the Share gets everything.
+func (cp CreateParams) sendAllAmounts(total int64) []int64 {
+	amounts := make([]int64, len(cp.destInfos))
+	var sumOfShares int64
+	for _, info := range cp.destInfos {
+		sumOfShares += info.Share
+	}
+	if sumOfShares == 0 {
+		amounts[0] = total
+		return amounts
+	}
+	var assigned int64
+	for i, info := range cp.destInfos {
+		if total > 0 {
+			amounts[i] = mulDiv(total, info.Share, sumOfShares)
+		}
+		assigned += amounts[i]
+	}
+	amounts[0] += total - assigned
+	return amounts
+}
+
+// checkSendAllAmounts fails if a destination of SendAll gets the dust.
+func checkSendAllAmounts(params CreateParams, addrs []address) error {
+	if !params.SendAll {
+		return nil
+	}
+	amounts := params.sendAllAmounts(calcBalanceOfAddresses(addrs) - params.MinerFee)
+	for i, info := range params.destInfos {
+		if info.Address == "" {
+			// the data output
+			continue
+		}
+		if limit := DustLimit(info.pkScript, params.DustRelayFee); amounts[i] < limit {
+			return fmt.Errorf("%w, SendAll leaves %d satoshi to %s, can't be less than %d", ErrDustOutput, amounts[i], info.Address, limit)
+		}
+	}
+	return nil
+}
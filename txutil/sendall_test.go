@@ -0,0 +1,61 @@
+package txutil
+
+import (
+	"errors"
+	"github.com/glossd/btc/addressinfo"
+	"github.com/glossd/btc/netchain"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCreate_SendAllShares(t *testing.T) {
+	params := CreateParams{
+		PrivateKey: privateKey1,
+		Destinations: []Destination{
+			{Address: destination1, Share: 70},
+			{Address: destination2, Share: 20},
+			{Address: destination3, Share: 10},
+		},
+		SendAll: true,
+		FeeRate: 3,
+		Fetch:   addressinfo.FetchMock,
+		Net:     netchain.TestNet,
+	}
+
+	t.Run("Split", func(t *testing.T) {
+		result, err := CreateWithResult(params)
+		assert.Nil(t, err)
+		tx := decodeTx(t, result.RawTx)
+		assert.EqualValues(t, 3, len(tx.TxOut))
+		total := addressinfo.MockAddressBalance - result.Fee
+		assert.EqualValues(t, total*2/10, outputTo(t, tx, addressPkScript(t, destination2)).Value)
+		assert.EqualValues(t, total/10, outputTo(t, tx, addressPkScript(t, destination3)).Value)
+		assert.EqualValues(t, total-total*2/10-total/10, outputTo(t, tx, addressPkScript(t, destination1)).Value)
+	})
+	t.Run("MissingShare", func(t *testing.T) {
+		p := params
+		p.Destinations = []Destination{{Address: destination1, Share: 70}, {Address: destination2}}
+		_, err := Create(p)
+		assert.NotNil(t, err)
+	})
+	t.Run("Dust", func(t *testing.T) {
+		p := params
+		p.Destinations = []Destination{{Address: destination1, Share: 1e6}, {Address: destination2, Share: 1}}
+		_, err := Create(p)
+		assert.True(t, errors.Is(err, ErrDustOutput))
+	})
+}
+
+func TestSendAllAmounts(t *testing.T) {
+	params := CreateParams{destInfos: []destinationInfo{
+		{Destination: Destination{Share: 1}},
+		{Destination: Destination{Share: 1}},
+		{Destination: Destination{Share: 1}},
+		{},
+	}}
+	// the first destination gets the remainder, the data output nothing
+	assert.EqualValues(t, []int64{3334, 3333, 3333, 0}, params.sendAllAmounts(10000))
+
+	params.destInfos = []destinationInfo{{}, {}}
+	assert.EqualValues(t, []int64{10000, 0}, params.sendAllAmounts(10000))
+}
@@ -0,0 +1,124 @@
+package txutil
+
+import (
+	"fmt"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// Signer signs the transactions with the private key it keeps, e.g. in KMS, HSM or on a remote machine,
+// so that the key never has to be in CreateParams. Its P2PKH address hashes the compressed public key.
+type Signer interface {
+	// PublicKey returns the public key of the private key.
+	PublicKey() *btcec.PublicKey
+	// Sign returns the DER-encoded ECDSA signature of the 32-byte sighash.
+	Sign(sigHash []byte) ([]byte, error)
+}
+
+// TaprootSigner is the Signer which can also spend from P2TR addresses.
+type TaprootSigner interface {
+	Signer
+	// SignTaproot returns the BIP340 Schnorr signature of the 32-byte sighash
+	// made with the private key tweaked by BIP341 without a script tree.
+	SignTaproot(sigHash []byte) ([]byte, error)
+}
+
+// NewWIFSigner returns the TaprootSigner of the WIF-format private key.
+func NewWIFSigner(privateKey string) (TaprootSigner, error) {
+	wif, err := btcutil.DecodeWIF(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return wifSigner{privKey: wif.PrivKey, compressed: wif.CompressPubKey}, nil
+}
+
+type wifSigner struct {
+	privKey    *btcec.PrivateKey
+	compressed bool
+}
+
+// isUncompressed tells whether the P2PKH address of the signer hashes the uncompressed public key,
+// which is only the case for the uncompressed WIF keys.
+func isUncompressed(signer Signer) bool {
+	s, ok := signer.(wifSigner)
+	return ok && !s.compressed
+}
+
+func (s wifSigner) PublicKey() *btcec.PublicKey {
+	return s.privKey.PubKey()
+}
+
+func (s wifSigner) Sign(sigHash []byte) ([]byte, error) {
+	return ecdsa.Sign(s.privKey, sigHash).Serialize(), nil
+}
+
+func (s wifSigner) SignTaproot(sigHash []byte) ([]byte, error) {
+	sig, err := schnorr.Sign(txscript.TweakTaprootPrivKey(*s.privKey, nil), sigHash)
+	if err != nil {
+		return nil, err
+	}
+	return sig.Serialize(), nil
+}
+
+// signECDSA returns the signature of the sighash with the sighash type appended.
+// The signature of the Signer is checked, and its S is lowered as the nodes require.
+func signECDSA(signer Signer, sigHash []byte) ([]byte, error) {
+	der, err := signer.Sign(sigHash)
+	if err != nil {
+		return nil, fmt.Errorf("signer failed: %w", err)
+	}
+	sig, err := ecdsa.ParseDERSignature(der)
+	if err != nil {
+		return nil, fmt.Errorf("signer returned malformed signature: %w", err)
+	}
+	if !sig.Verify(sigHash, signer.PublicKey()) {
+		return nil, fmt.Errorf("signer returned signature of another key")
+	}
+	return append(sig.Serialize(), byte(txscript.SigHashAll)), nil
+}
+
+// rawTxInSignature works as txscript.RawTxInSignature with the Signer.
+func rawTxInSignature(tx *wire.MsgTx, idx int, script []byte, signer Signer) ([]byte, error) {
+	sigHash, err := txscript.CalcSignatureHash(script, txscript.SigHashAll, tx, idx)
+	if err != nil {
+		return nil, err
+	}
+	return signECDSA(signer, sigHash)
+}
+
+// rawTxInWitnessSignature works as txscript.RawTxInWitnessSignature with the Signer.
+func rawTxInWitnessSignature(tx *wire.MsgTx, sigHashes *txscript.TxSigHashes, idx int, amount int64, script []byte, signer Signer) ([]byte, error) {
+	sigHash, err := txscript.CalcWitnessSigHash(script, sigHashes, txscript.SigHashAll, tx, idx, amount)
+	if err != nil {
+		return nil, err
+	}
+	return signECDSA(signer, sigHash)
+}
+
+// rawTxInTaprootSignature works as txscript.RawTxInTaprootSignature of the key path with the Signer.
+func rawTxInTaprootSignature(tx *wire.MsgTx, sigHashes *txscript.TxSigHashes, idx int, amount int64, pkScript []byte, signer Signer) ([]byte, error) {
+	taprootSigner, ok := signer.(TaprootSigner)
+	if !ok {
+		return nil, fmt.Errorf("signer of input %d can't spend from P2TR address, it must implement TaprootSigner", idx)
+	}
+	sigHash, err := txscript.CalcTaprootSignatureHash(sigHashes, txscript.SigHashDefault, tx, idx, txscript.NewCannedPrevOutputFetcher(pkScript, amount))
+	if err != nil {
+		return nil, err
+	}
+	sig, err := taprootSigner.SignTaproot(sigHash)
+	if err != nil {
+		return nil, fmt.Errorf("signer failed: %w", err)
+	}
+	parsed, err := schnorr.ParseSignature(sig)
+	if err != nil {
+		return nil, fmt.Errorf("signer returned malformed signature: %w", err)
+	}
+	if !parsed.Verify(sigHash, txscript.ComputeTaprootKeyNoScript(signer.PublicKey())) {
+		return nil, fmt.Errorf("signer returned signature of another key")
+	}
+	return sig, nil
+}
@@ -0,0 +1,112 @@
+package txutil
+
+import (
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/glossd/btc/addressinfo"
+	"github.com/glossd/btc/netchain"
+	"github.com/glossd/btc/wallet"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// remoteSigner stands for the key kept in KMS, it only signs the sighashes it's given.
+type remoteSigner struct {
+	Signer
+	calls *int
+}
+
+func (s remoteSigner) Sign(sigHash []byte) ([]byte, error) {
+	*s.calls++
+	return s.Signer.Sign(sigHash)
+}
+
+func newRemoteSigner(t *testing.T, privateKey string) remoteSigner {
+	signer, err := NewWIFSigner(privateKey)
+	assert.Nil(t, err)
+	return remoteSigner{Signer: signer, calls: new(int)}
+}
+
+func TestCreate_Signers(t *testing.T) {
+	for _, addrType := range []wallet.AddressType{wallet.P2PKH, wallet.P2WPKH, wallet.P2SHP2WPKH} {
+		t.Run(addrType.String(), func(t *testing.T) {
+			signer := newRemoteSigner(t, privateKey2)
+			rawTx, err := Create(CreateParams{
+				Signers:     []Signer{signer},
+				AddressType: addrType,
+				Destination: destination1,
+				Amount:      5e5,
+				Fetch:       addressinfo.FetchMock,
+				Net:         netchain.TestNet,
+			})
+			assert.Nil(t, err)
+			assert.EqualValues(t, 1, *signer.calls)
+
+			addr, err := wallet.AddressFromPrivateKeyWithType(privateKey2, netchain.TestNet, addrType)
+			assert.Nil(t, err)
+			verifyInput(t, decodeTx(t, rawTx), 0, addressPkScript(t, addr), addressinfo.MockAddressBalance)
+		})
+	}
+	t.Run("Taproot", func(t *testing.T) {
+		signer, err := NewWIFSigner(privateKey2)
+		assert.Nil(t, err)
+		params := CreateParams{
+			Signers:     []Signer{signer},
+			AddressType: wallet.P2TR,
+			Destination: destination1,
+			Amount:      5e5,
+			Fetch:       addressinfo.FetchMock,
+			Net:         netchain.TestNet,
+		}
+		rawTx, err := Create(params)
+		assert.Nil(t, err)
+		addr, err := wallet.AddressFromPrivateKeyWithType(privateKey2, netchain.TestNet, wallet.P2TR)
+		assert.Nil(t, err)
+		verifyInput(t, decodeTx(t, rawTx), 0, addressPkScript(t, addr), addressinfo.MockAddressBalance)
+
+		params.Signers = []Signer{newRemoteSigner(t, privateKey2)}
+		_, err = Create(params)
+		assert.NotNil(t, err, "the signer doesn't implement TaprootSigner")
+	})
+	t.Run("Multisig", func(t *testing.T) {
+		multisig := multisigOf(t, 2, privateKey1, privateKey2, privateKey3)
+		rawTx, err := Create(CreateParams{
+			Multisig:    multisig,
+			PrivateKeys: []string{privateKey1},
+			Signers:     []Signer{newRemoteSigner(t, privateKey3)},
+			AddressType: wallet.P2WSH,
+			Destination: destination1,
+			Amount:      5e5,
+			Fetch:       addressinfo.FetchMock,
+			Net:         netchain.TestNet,
+		})
+		assert.Nil(t, err)
+		addr, err := wallet.MultisigAddress(multisig.Required, multisig.PublicKeys, netchain.TestNet, wallet.P2WSH)
+		assert.Nil(t, err)
+		verifyInput(t, decodeTx(t, rawTx), 0, addressPkScript(t, addr), addressinfo.MockAddressBalance)
+	})
+}
+
+// wrongKeySigner claims one public key but signs with another.
+type wrongKeySigner struct {
+	Signer
+	pubKey *btcec.PublicKey
+}
+
+func (s wrongKeySigner) PublicKey() *btcec.PublicKey {
+	return s.pubKey
+}
+
+func TestCreate_SignerOfAnotherKey(t *testing.T) {
+	signer, err := NewWIFSigner(privateKey1)
+	assert.Nil(t, err)
+	other, err := NewWIFSigner(privateKey3)
+	assert.Nil(t, err)
+	_, err = Create(CreateParams{
+		Signers:     []Signer{wrongKeySigner{Signer: signer, pubKey: other.PublicKey()}},
+		Destination: destination2,
+		Amount:      5e5,
+		Fetch:       addressinfo.FetchMock,
+		Net:         netchain.TestNet,
+	})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,81 @@
+package txutil
+
+import (
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// Sizes of the parts of a transaction in weight units, where a non-witness byte weighs 4 and a witness byte weighs 1.
+// Signatures are counted at their maximum length, so the estimated fee never falls short of the fee rate.
+const (
+	// version and locktime
+	txOverheadWeight = (4 + 4) * 4
+	// marker and flag of transactions with witness inputs
+	segWitMarkerWeight = 2
+	// outpoint, sequence and the length of the signature script
+	inputBaseWeight = (36 + 4 + 1) * 4
+	// DER-encoded signature with the sighash type
+	maxECDSASigSize = 73
+	// BIP340 signature with the default sighash type omitted
+	schnorrSigSize = 64
+
+	// signature script of the signature and the compressed public key
+	p2pkhInputWeight = inputBaseWeight + (1+maxECDSASigSize+1+33)*4
+	// signature script of the signature and the uncompressed public key of the legacy WIF
+	p2pkhUncompressedInputWeight = inputBaseWeight + (1+maxECDSASigSize+1+65)*4
+	// witness of the signature and the compressed public key
+	p2wpkhInputWeight = inputBaseWeight + 1 + 1 + maxECDSASigSize + 1 + 33
+	// the witness of P2WPKH and the push of its 22-byte program in the signature script
+	p2shP2wpkhInputWeight = p2wpkhInputWeight + (1+22)*4
+	// witness of the key path signature
+	p2trInputWeight = inputBaseWeight + 1 + 1 + schnorrSigSize
+)
+
+// estimateVSize computes the virtual size the transaction will have once its inputs are signed.
+func estimateVSize(tx *wire.MsgTx, addresses []address) (int64, error) {
+	utxosOfIns, err := utxosOfInputs(tx, addresses)
+	if err != nil {
+		return 0, err
+	}
+	weight := txOverheadWeight + (wire.VarIntSerializeSize(uint64(len(tx.TxIn)))+wire.VarIntSerializeSize(uint64(len(tx.TxOut))))*4
+	var hasWitness bool
+	var legacyInputs int
+	for _, utxo := range utxosOfIns {
+		w, witness := inputWeight(utxo)
+		weight += w
+		hasWitness = hasWitness || witness
+		if !witness {
+			legacyInputs++
+		}
+	}
+	if hasWitness {
+		// legacy inputs of a SegWit transaction have the empty witness
+		weight += segWitMarkerWeight + legacyInputs
+	}
+	for _, out := range tx.TxOut {
+		weight += outputWeight(out.PkScript)
+	}
+	return int64((weight + 3) / 4), nil
+}
+
+// inputWeight returns the weight of the input spending the UTXO and whether it has the witness.
+func inputWeight(utxo utxoWithKey) (int, bool) {
+	switch {
+	case utxo.pkInfo.multisigScript() != nil:
+		return multisigInputWeight(utxo.pkInfo)
+	case txscript.IsPayToTaproot(utxo.pkScript):
+		return p2trInputWeight, true
+	case txscript.IsPayToWitnessPubKeyHash(utxo.pkScript):
+		return p2wpkhInputWeight, true
+	case txscript.IsPayToScriptHash(utxo.pkScript):
+		return p2shP2wpkhInputWeight, true
+	case utxo.pkInfo.uncompressed:
+		return p2pkhUncompressedInputWeight, false
+	default:
+		return p2pkhInputWeight, false
+	}
+}
+
+func outputWeight(pkScript []byte) int {
+	return (8 + wire.VarIntSerializeSize(uint64(len(pkScript))) + len(pkScript)) * 4
+}
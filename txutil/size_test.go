@@ -0,0 +1,86 @@
+package txutil
+
+import (
+	"github.com/glossd/btc/addressinfo"
+	"github.com/glossd/btc/netchain"
+	"github.com/glossd/btc/wallet"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCreate_FeeRate(t *testing.T) {
+	for _, addrType := range []wallet.AddressType{wallet.P2PKH, wallet.P2WPKH, wallet.P2SHP2WPKH, wallet.P2TR} {
+		t.Run(addrType.String(), func(t *testing.T) {
+			rawTx, err := Create(CreateParams{
+				PrivateKey:  privateKey2,
+				AddressType: addrType,
+				Destination: destination1,
+				Amount:      5e5,
+				FeeRate:     10,
+				Fetch:       addressinfo.FetchMock,
+				Net:         netchain.TestNet,
+			})
+			assert.Nil(t, err)
+			tx := decodeTx(t, rawTx)
+			assert.EqualValues(t, 2, len(tx.TxOut))
+			fee := addressinfo.MockAddressBalance - sumOutputs(tx.TxOut)
+			// the estimation takes the longest signature, which can be 2 bytes longer than the real one
+			assert.GreaterOrEqual(t, fee, 10*virtualSize(tx))
+			assert.LessOrEqual(t, fee, 10*(virtualSize(tx)+2))
+		})
+	}
+	t.Run("DroppingChange", func(t *testing.T) {
+		// a P2WPKH input and a P2PKH output are 113 vbytes, the change of 100 satoshi isn't worth its output
+		rawTx, err := Create(CreateParams{
+			PrivateKey:  privateKey2,
+			AddressType: wallet.P2WPKH,
+			Destination: destination1,
+			Amount:      addressinfo.MockAddressBalance - 1130 - 100,
+			FeeRate:     10,
+			Fetch:       addressinfo.FetchMock,
+			Net:         netchain.TestNet,
+		})
+		assert.Nil(t, err)
+		tx := decodeTx(t, rawTx)
+		assert.EqualValues(t, 1, len(tx.TxOut))
+		assert.GreaterOrEqual(t, addressinfo.MockAddressBalance-tx.TxOut[0].Value, 10*virtualSize(tx))
+	})
+	t.Run("AddingInput", func(t *testing.T) {
+		// the first key covers the amount but not the fee
+		rawTx, err := Create(CreateParams{
+			PrivateKeys: []string{privateKey1, privateKey2},
+			AddressType: wallet.P2WPKH,
+			Destination: destination3,
+			Amount:      addressinfo.MockAddressBalance - 500,
+			FeeRate:     10,
+			Fetch:       addressinfo.FetchMock,
+			Net:         netchain.TestNet,
+		})
+		assert.Nil(t, err)
+		tx := decodeTx(t, rawTx)
+		assert.EqualValues(t, 2, len(tx.TxIn))
+		assert.EqualValues(t, 2, len(tx.TxOut))
+		fee := 2*addressinfo.MockAddressBalance - sumOutputs(tx.TxOut)
+		assert.GreaterOrEqual(t, fee, 10*virtualSize(tx))
+	})
+	t.Run("PSBT", func(t *testing.T) {
+		packet, err := CreatePSBT(CreateParams{
+			PublicKeys:  []string{publicKeyOf(t, privateKey2)},
+			AddressType: wallet.P2TR,
+			Destination: destination1,
+			Amount:      5e5,
+			Fetch:       addressinfo.FetchMock,
+			GetSatoshiPerByte: func(net netchain.Net) (int, error) {
+				return 10, nil
+			},
+			AutoMinerFee: true,
+			Net:          netchain.TestNet,
+		})
+		assert.Nil(t, err)
+		signed, err := SignPSBT(packet, []string{privateKey2}, netchain.TestNet)
+		assert.Nil(t, err)
+		tx := finalizeAndExtract(t, signed)
+		// Schnorr signatures have the fixed length
+		assert.EqualValues(t, 10*virtualSize(tx), addressinfo.MockAddressBalance-sumOutputs(tx.TxOut))
+	})
+}
@@ -0,0 +1,69 @@
+package txutil
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// subtractsFee tells whether the destinations pay the fee instead of the sender.
+func (cp CreateParams) subtractsFee() bool {
+	if cp.SendAll {
+		return false
+	}
+	for _, info := range cp.destInfos {
+		if info.SubtractFee {
+			return true
+		}
+	}
+	return false
+}
+
+// feeShares returns the part of the MinerFee each destination pays. As in Bitcoin Core,
+// the first of the SubtractFee destinations also pays the remainder of the split.
+func (cp CreateParams) feeShares() []int64 {
+	shares := make([]int64, len(cp.destInfos))
+	var flagged []int
+	var flaggedAmount int64
+	for i, info := range cp.destInfos {
+		if info.SubtractFee {
+			flagged = append(flagged, i)
+			flaggedAmount += info.Amount
+		}
+	}
+	if len(flagged) == 0 || cp.SendAll {
+		return shares
+	}
+	var assigned int64
+	for _, i := range flagged {
+		if cp.SubtractFeeByAmount {
+			shares[i] = mulDiv(cp.MinerFee, cp.destInfos[i].Amount, flaggedAmount)
+		} else {
+			shares[i] = cp.MinerFee / int64(len(flagged))
+		}
+		assigned += shares[i]
+	}
+	shares[flagged[0]] += cp.MinerFee - assigned
+	return shares
+}
+
+// mulDiv returns a*b/c rounded down without overflowing, b must not exceed c.
+func mulDiv(a, b, c int64) int64 {
+	hi, lo := bits.Mul64(uint64(a), uint64(b))
+	q, _ := bits.Div64(hi, lo, uint64(c))
+	return int64(q)
+}
+
+// checkFeeShares fails if a destination is left with the dust after paying its share of the fee.
+func checkFeeShares(params CreateParams) error {
+	for i, share := range params.feeShares() {
+		info := params.destInfos[i]
+		if share == 0 {
+			continue
+		}
+		if limit := DustLimit(info.pkScript, params.DustRelayFee); info.Amount-share < limit {
+			return fmt.Errorf("%w, amount of satoshi to %s is %d after paying %d of the fee, can't be less than %d",
+				ErrDustOutput, info.Address, info.Amount-share, share, limit)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package txutil
+
+import (
+	"errors"
+	"github.com/glossd/btc/addressinfo"
+	"github.com/glossd/btc/netchain"
+	"github.com/glossd/btc/wallet"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCreate_SubtractFee(t *testing.T) {
+	segwitAddr, err := wallet.AddressFromPrivateKeyWithType(privateKey2, netchain.TestNet, wallet.P2WPKH)
+	assert.Nil(t, err)
+	params := CreateParams{
+		PrivateKey:  privateKey2,
+		AddressType: wallet.P2WPKH,
+		Destinations: []Destination{
+			{Address: destination1, Amount: 2e5, SubtractFee: true},
+			{Address: destination2, Amount: 3e5},
+			{Address: destination3, Amount: 4e5, SubtractFee: true},
+		},
+		FeeRate: 7,
+		Fetch:   addressinfo.FetchMock,
+		Net:     netchain.TestNet,
+	}
+
+	t.Run("Evenly", func(t *testing.T) {
+		result, err := CreateWithResult(params)
+		assert.Nil(t, err)
+		tx := decodeTx(t, result.RawTx)
+		assert.GreaterOrEqual(t, result.Fee, 7*virtualSize(tx))
+		assert.EqualValues(t, 2e5-result.Fee/2-result.Fee%2, outputTo(t, tx, addressPkScript(t, destination1)).Value)
+		assert.EqualValues(t, 3e5, outputTo(t, tx, addressPkScript(t, destination2)).Value)
+		assert.EqualValues(t, 4e5-result.Fee/2, outputTo(t, tx, addressPkScript(t, destination3)).Value)
+		// the sender doesn't pay the fee
+		assert.EqualValues(t, addressinfo.MockAddressBalance-9e5, outputTo(t, tx, addressPkScript(t, segwitAddr)).Value)
+	})
+	t.Run("ByAmount", func(t *testing.T) {
+		p := params
+		p.SubtractFeeByAmount = true
+		result, err := CreateWithResult(p)
+		assert.Nil(t, err)
+		tx := decodeTx(t, result.RawTx)
+		share3 := result.Fee * 4 / 6
+		assert.EqualValues(t, 2e5-(result.Fee-share3), outputTo(t, tx, addressPkScript(t, destination1)).Value)
+		assert.EqualValues(t, 4e5-share3, outputTo(t, tx, addressPkScript(t, destination3)).Value)
+	})
+	t.Run("FixedMinerFee", func(t *testing.T) {
+		p := params
+		p.FeeRate = 0
+		p.MinerFee = 3001
+		rawTx, err := Create(p)
+		assert.Nil(t, err)
+		tx := decodeTx(t, rawTx)
+		assert.EqualValues(t, 2e5-1501, outputTo(t, tx, addressPkScript(t, destination1)).Value)
+		assert.EqualValues(t, 4e5-1500, outputTo(t, tx, addressPkScript(t, destination3)).Value)
+		assert.EqualValues(t, addressinfo.MockAddressBalance-9e5, outputTo(t, tx, addressPkScript(t, segwitAddr)).Value)
+	})
+	t.Run("CoinSelector", func(t *testing.T) {
+		p := params
+		p.CoinSelector = LargestFirst{}
+		result, err := CreateWithResult(p)
+		assert.Nil(t, err)
+		tx := decodeTx(t, result.RawTx)
+		assert.EqualValues(t, 3e5, outputTo(t, tx, addressPkScript(t, destination2)).Value)
+		assert.EqualValues(t, addressinfo.MockAddressBalance-9e5, outputTo(t, tx, addressPkScript(t, segwitAddr)).Value)
+	})
+	t.Run("Dust", func(t *testing.T) {
+		p := params
+		p.Destinations = []Destination{{Address: destination1, Amount: 600, SubtractFee: true}}
+		_, err := Create(p)
+		assert.True(t, errors.Is(err, ErrDustOutput))
+	})
+}
+
+func TestFeeShares(t *testing.T) {
+	params := CreateParams{MinerFee: 1001, destInfos: []destinationInfo{
+		{Destination: Destination{Amount: 1000}},
+		{Destination: Destination{Amount: 1000, SubtractFee: true}},
+		{Destination: Destination{Amount: 3000, SubtractFee: true}},
+		{Destination: Destination{Amount: 6000, SubtractFee: true}},
+	}}
+	// the first of the paying destinations pays the remainder
+	assert.EqualValues(t, []int64{0, 335, 333, 333}, params.feeShares())
+
+	params.SubtractFeeByAmount = true
+	assert.EqualValues(t, []int64{0, 101, 300, 600}, params.feeShares())
+}
@@ -0,0 +1,178 @@
+package txutil
+
+import (
+	"fmt"
+	"github.com/glossd/btc/addressinfo"
+	"github.com/glossd/btc/netchain"
+	"github.com/glossd/btc/wallet"
+)
+
+// The largest transaction in vbytes nodes relay, 400000 weight units of MAX_STANDARD_TX_WEIGHT of Bitcoin Core.
+const maxStandardTxVSize = 100000
+
+type SweepParams struct {
+	// WIF-format keys to move all the bitcoins from, e.g. of paper wallets.
+	PrivateKeys []string
+	// Type of the addresses of the PrivateKeys, defaults to wallet.P2PKH.
+	AddressType wallet.AddressType
+	// Bitcoin address receiving everything.
+	Destination string
+	// In sat/vB, defaults to the rate of GetSatoshiPerByte.
+	FeeRate int64
+	// The largest transaction Sweep creates, defaults to 100000 vB, the most nodes relay.
+	MaxVSize int64
+	// defaults to netchain.MainNet.
+	Net netchain.Net
+	// defaults to addressinfo.FetchFromBlockcypher.
+	Fetch addressinfo.Fetch
+	// defaults to addressinfo.GetSatoshiPerByteFromBlockchain.
+	GetSatoshiPerByte addressinfo.GetSatoshiPerByte
+}
+
+// Sweep sends all the bitcoins of the private keys to the destination.
+// The UTXOs are split into as many transactions as it takes to keep each of them under the MaxVSize,
+// the results go in the order the keys and their UTXOs were fetched. Any of them can be broadcast on its own.
+// The UTXOs which don't pay for their own input at the FeeRate are left unspent.
+func Sweep(params SweepParams) ([]CreateResult, error) {
+	params, err := checkSweepParams(params)
+	if err != nil {
+		return nil, err
+	}
+	utxos, err := fetchUTXOsToSweep(params)
+	if err != nil {
+		return nil, err
+	}
+	return sweepUTXOs(params, utxos)
+}
+
+// sweepUTXOs creates the transactions sending the UTXOs to the Destination.
+func sweepUTXOs(params SweepParams, utxos []sweptUTXO) ([]CreateResult, error) {
+	destScript, err := addressToPkScript(params.Destination, params.Net)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []CreateResult
+	for _, batch := range splitUTXOs(utxos, destScript, params.MaxVSize) {
+		var keys []string
+		var batchUTXOs []addressinfo.UTXO
+		keyAdded := make(map[string]bool)
+		for _, u := range batch {
+			if !keyAdded[u.privateKey] {
+				keyAdded[u.privateKey] = true
+				keys = append(keys, u.privateKey)
+			}
+			batchUTXOs = append(batchUTXOs, u.UTXO)
+		}
+		result, err := CreateWithResult(CreateParams{
+			PrivateKeys: keys,
+			AddressType: params.AddressType,
+			UTXOs:       batchUTXOs,
+			Destination: params.Destination,
+			SendAll:     true,
+			FeeRate:     params.FeeRate,
+			Net:         params.Net,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("transaction %d of the sweep: %w", len(results)+1, err)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func checkSweepParams(p SweepParams) (SweepParams, error) {
+	if len(p.PrivateKeys) == 0 {
+		return SweepParams{}, fmt.Errorf("must specify PrivateKeys")
+	}
+	if p.Destination == "" {
+		return SweepParams{}, fmt.Errorf("must specify Destination")
+	}
+	if p.FeeRate < 0 {
+		return SweepParams{}, fmt.Errorf("FeeRate can't be negative")
+	}
+	if p.MaxVSize == 0 {
+		p.MaxVSize = maxStandardTxVSize
+	}
+	if p.MaxVSize < 0 || p.MaxVSize > maxStandardTxVSize {
+		return SweepParams{}, fmt.Errorf("MaxVSize must be from 1 to %d", maxStandardTxVSize)
+	}
+	if p.Net == "" {
+		p.Net = netchain.MainNet
+	}
+	if err := checkNet(p.Net); err != nil {
+		return SweepParams{}, err
+	}
+	if p.Fetch == nil {
+		p.Fetch = addressinfo.FetchFromBlockcypher
+	}
+	if p.GetSatoshiPerByte == nil {
+		p.GetSatoshiPerByte = addressinfo.GetSatoshiPerByteFromBlockchain
+	}
+	if p.FeeRate == 0 {
+		satoshiPerByte, err := p.GetSatoshiPerByte(p.Net)
+		if err != nil {
+			return SweepParams{}, fmt.Errorf("couldn't fetch satoshiPerByte: %w", err)
+		}
+		p.FeeRate = int64(satoshiPerByte)
+	}
+	return p, nil
+}
+
+// sweptUTXO is the UTXO with the key spending it and the weight of its input.
+type sweptUTXO struct {
+	addressinfo.UTXO
+	privateKey string
+	weight     int
+}
+
+// fetchUTXOsToSweep returns the UTXOs of all the keys worth spending at the FeeRate.
+func fetchUTXOsToSweep(params SweepParams) ([]sweptUTXO, error) {
+	var utxos []sweptUTXO
+	for _, privKey := range params.PrivateKeys {
+		info, err := toPkInfo(privKey, params.AddressType, params.Net)
+		if err != nil {
+			return nil, err
+		}
+		addr, err := params.Fetch(info.address, params.Net)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range addr.UTXOs {
+			if !isSpendable(u, 0) {
+				continue
+			}
+			w, witness := inputWeight(utxoWithKey{UTXO: u, pkScript: info.pkScript, pkInfo: info})
+			if !witness {
+				// the empty witness of the legacy input in the SegWit transaction
+				w++
+			}
+			if u.Balance <= feeOfWeight(params.FeeRate, w) {
+				continue
+			}
+			utxos = append(utxos, sweptUTXO{UTXO: u, privateKey: privKey, weight: w})
+		}
+	}
+	if len(utxos) == 0 {
+		return nil, fmt.Errorf("%w, none of the UTXOs pays for its input at %d sat/vB", ErrInsufficientFunds, params.FeeRate)
+	}
+	return utxos, nil
+}
+
+// splitUTXOs groups the UTXOs into the transactions of at most maxVSize paying to the destination script.
+func splitUTXOs(utxos []sweptUTXO, destScript []byte, maxVSize int64) [][]sweptUTXO {
+	// the count of the inputs takes up to 3 bytes, the count of the single output 1
+	overhead := txOverheadWeight + (3+1)*4 + segWitMarkerWeight + outputWeight(destScript)
+	var batches [][]sweptUTXO
+	var batch []sweptUTXO
+	weight := overhead
+	for _, u := range utxos {
+		if len(batch) > 0 && int64(weight+u.weight) > maxVSize*4 {
+			batches = append(batches, batch)
+			batch, weight = nil, overhead
+		}
+		batch = append(batch, u)
+		weight += u.weight
+	}
+	return append(batches, batch)
+}
@@ -0,0 +1,51 @@
+package txutil
+
+import (
+	"errors"
+	"github.com/glossd/btc/addressinfo"
+	"github.com/glossd/btc/netchain"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSweep(t *testing.T) {
+	params := SweepParams{
+		PrivateKeys: []string{privateKey1, privateKey2, privateKey3},
+		Destination: destination1,
+		FeeRate:     2,
+		Fetch:       addressinfo.FetchMock,
+		Net:         netchain.TestNet,
+	}
+
+	t.Run("OneTransaction", func(t *testing.T) {
+		results, err := Sweep(params)
+		assert.Nil(t, err)
+		assert.EqualValues(t, 1, len(results))
+		tx := decodeTx(t, results[0].RawTx)
+		assert.EqualValues(t, 3, len(tx.TxIn))
+		assert.EqualValues(t, 1, len(tx.TxOut))
+		assert.EqualValues(t, 3*addressinfo.MockAddressBalance-results[0].Fee, tx.TxOut[0].Value)
+	})
+	t.Run("Split", func(t *testing.T) {
+		p := params
+		// two legacy inputs fit
+		p.MaxVSize = 450
+		results, err := Sweep(p)
+		assert.Nil(t, err)
+		assert.EqualValues(t, 2, len(results))
+		var inputs int
+		for _, result := range results {
+			tx := decodeTx(t, result.RawTx)
+			assert.LessOrEqual(t, virtualSize(tx), p.MaxVSize)
+			assert.GreaterOrEqual(t, result.Fee, p.FeeRate*virtualSize(tx))
+			inputs += len(tx.TxIn)
+		}
+		assert.EqualValues(t, 3, inputs)
+	})
+	t.Run("Uneconomic", func(t *testing.T) {
+		p := params
+		p.FeeRate = addressinfo.MockAddressBalance
+		_, err := Sweep(p)
+		assert.True(t, errors.Is(err, ErrInsufficientFunds))
+	})
+}
@@ -0,0 +1,68 @@
+package txutil
+
+import (
+	"fmt"
+	"github.com/btcsuite/btcd/wire"
+	"time"
+)
+
+// Locktimes below the threshold are block heights, the others are unix times.
+const lockTimeThreshold = 500000000
+
+// BIP68 relative locks in time are counted in the units of 512 seconds.
+const relativeLockTimeGranularity = 512 * time.Second
+
+// Relative locks require the version 2 of BIP68.
+const relativeLockTxVersion = 2
+
+// RelativeLockBlocks returns the sequence of the input which can't be mined until the UTXO has the confirmations.
+func RelativeLockBlocks(blocks uint16) uint32 {
+	return uint32(blocks)
+}
+
+// RelativeLockTime returns the sequence of the input which can't be mined until the time passes since the UTXO was mined.
+// The time is rounded up to 512 seconds and can't exceed 388 days.
+func RelativeLockTime(d time.Duration) (uint32, error) {
+	units := (d + relativeLockTimeGranularity - 1) / relativeLockTimeGranularity
+	if d < 0 || units > wire.SequenceLockTimeMask {
+		return 0, fmt.Errorf("relative lock time must be between 0 and %s", relativeLockTimeGranularity*wire.SequenceLockTimeMask)
+	}
+	return wire.SequenceLockTimeIsSeconds | uint32(units), nil
+}
+
+func checkLockTime(p CreateParams) error {
+	if p.LockTimeHeight != 0 && p.LockTimeUnix != 0 {
+		return fmt.Errorf("must specify either LockTimeHeight or LockTimeUnix")
+	}
+	if p.LockTimeHeight >= lockTimeThreshold {
+		return fmt.Errorf("LockTimeHeight must be below %d, bigger values are unix times", lockTimeThreshold)
+	}
+	if p.LockTimeUnix != 0 && (p.LockTimeUnix < lockTimeThreshold || p.LockTimeUnix > int64(^uint32(0))) {
+		return fmt.Errorf("LockTimeUnix must be between %d and %d, smaller values are block heights", lockTimeThreshold, ^uint32(0))
+	}
+	return nil
+}
+
+// setLocks sets the locktime and the sequences of the inputs spending the UTXOs of the addresses.
+func setLocks(tx *wire.MsgTx, params CreateParams, addrs []address) error {
+	tx.LockTime = params.lockTime()
+	if params.Sequence == nil {
+		return nil
+	}
+	utxosOfIns, err := utxosOfInputs(tx, addrs)
+	if err != nil {
+		return err
+	}
+	final := true
+	for i, in := range tx.TxIn {
+		in.Sequence = params.Sequence(utxosOfIns[i].UTXO)
+		final = final && in.Sequence == wire.MaxTxInSequenceNum
+		if in.Sequence&wire.SequenceLockTimeDisabled == 0 {
+			tx.Version = relativeLockTxVersion
+		}
+	}
+	if final && tx.LockTime != 0 {
+		return fmt.Errorf("locktime has no effect when the sequences of all the inputs are final")
+	}
+	return nil
+}
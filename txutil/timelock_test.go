@@ -0,0 +1,83 @@
+package txutil
+
+import (
+	"github.com/btcsuite/btcd/wire"
+	"github.com/glossd/btc/addressinfo"
+	"github.com/glossd/btc/netchain"
+	"github.com/glossd/btc/wallet"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestCreate_LockTime(t *testing.T) {
+	params := CreateParams{
+		PrivateKey:  privateKey1,
+		Destination: destination2,
+		Amount:      5e5,
+		Fetch:       addressinfo.FetchMock,
+		Net:         netchain.TestNet,
+	}
+	t.Run("Height", func(t *testing.T) {
+		p := params
+		p.LockTimeHeight = 2500000
+		rawTx, err := Create(p)
+		assert.Nil(t, err)
+		tx := decodeTx(t, rawTx)
+		assert.EqualValues(t, 2500000, tx.LockTime)
+		assert.EqualValues(t, wire.MaxTxInSequenceNum-1, tx.TxIn[0].Sequence)
+	})
+	t.Run("UnixWithRBF", func(t *testing.T) {
+		p := params
+		p.LockTimeUnix = time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC).Unix()
+		p.RBF = true
+		rawTx, err := Create(p)
+		assert.Nil(t, err)
+		tx := decodeTx(t, rawTx)
+		assert.EqualValues(t, p.LockTimeUnix, tx.LockTime)
+		assert.True(t, signalsRBF(tx))
+	})
+	t.Run("Validation", func(t *testing.T) {
+		for _, p := range []CreateParams{
+			{LockTimeHeight: 100, LockTimeUnix: time.Now().Unix()},
+			{LockTimeHeight: lockTimeThreshold},
+			{LockTimeUnix: 1000},
+			{LockTimeHeight: 100, Sequence: func(utxo addressinfo.UTXO) uint32 { return wire.MaxTxInSequenceNum }},
+		} {
+			p.PrivateKey, p.Destination, p.Amount, p.Fetch, p.Net = params.PrivateKey, params.Destination, params.Amount, params.Fetch, params.Net
+			_, err := Create(p)
+			assert.NotNil(t, err)
+		}
+	})
+}
+
+func TestCreate_RelativeLock(t *testing.T) {
+	segwitAddr, err := wallet.AddressFromPrivateKeyWithType(privateKey2, netchain.TestNet, wallet.P2WPKH)
+	assert.Nil(t, err)
+	rawTx, err := Create(CreateParams{
+		PrivateKey:  privateKey2,
+		AddressType: wallet.P2WPKH,
+		Destination: destination1,
+		Amount:      5e5,
+		Sequence: func(utxo addressinfo.UTXO) uint32 {
+			return RelativeLockBlocks(144)
+		},
+		Fetch: addressinfo.FetchMock,
+		Net:   netchain.TestNet,
+	})
+	assert.Nil(t, err)
+	tx := decodeTx(t, rawTx)
+	assert.EqualValues(t, 2, tx.Version)
+	assert.EqualValues(t, 144, tx.TxIn[0].Sequence)
+	verifyInput(t, tx, 0, addressPkScript(t, segwitAddr), addressinfo.MockAddressBalance)
+}
+
+func TestRelativeLockTime(t *testing.T) {
+	sequence, err := RelativeLockTime(time.Hour)
+	assert.Nil(t, err)
+	// rounded up to 8 units of 512 seconds
+	assert.EqualValues(t, wire.SequenceLockTimeIsSeconds|8, sequence)
+
+	_, err = RelativeLockTime(400 * 24 * time.Hour)
+	assert.NotNil(t, err)
+}
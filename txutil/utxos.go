@@ -0,0 +1,49 @@
+package txutil
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/glossd/btc/addressinfo"
+	"github.com/glossd/btc/netchain"
+)
+
+// spendAll is the CoinSelector of the UTXOs of CreateParams, it spends every one of them.
+type spendAll struct{}
+
+func (spendAll) Select(coins []Coin, p SelectionParams) (CoinSelection, error) {
+	var value int64
+	for _, c := range coins {
+		value += c.EffectiveValue()
+	}
+	if value < p.Target {
+		return CoinSelection{}, &InsufficientFundsError{Needed: p.Target, Available: value}
+	}
+	return newCoinSelection(coins, p), nil
+}
+
+// fetchOfUTXOs returns the Fetch listing the UTXOs locked to the address instead of calling the API.
+func fetchOfUTXOs(utxos []addressinfo.UTXO, pkInfos []privateKeyInfo) (addressinfo.Fetch, error) {
+	addrs := make(map[string]addressinfo.Address)
+	outPoints := make(map[string]bool)
+	for _, u := range utxos {
+		if outPoints[outPointKey(u)] {
+			return nil, fmt.Errorf("UTXO %s is specified twice", outPointKey(u))
+		}
+		outPoints[outPointKey(u)] = true
+		pkScript, err := hex.DecodeString(u.Pbscript)
+		if err != nil {
+			return nil, fmt.Errorf("script of UTXO %s is malformed: %w", outPointKey(u), err)
+		}
+		info, ok := findKeyOfScript(pkInfos, pkScript)
+		if !ok {
+			return nil, fmt.Errorf("UTXO %s isn't locked to the address of any of the keys", outPointKey(u))
+		}
+		addr := addrs[info.address]
+		addr.UTXOs = append(addr.UTXOs, u)
+		addr.Balance += u.Balance
+		addrs[info.address] = addr
+	}
+	return func(address string, net netchain.Net) (addressinfo.Address, error) {
+		return addrs[address], nil
+	}, nil
+}
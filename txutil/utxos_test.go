@@ -0,0 +1,74 @@
+package txutil
+
+import (
+	"encoding/hex"
+	"errors"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/glossd/btc/addressinfo"
+	"github.com/glossd/btc/netchain"
+	"github.com/glossd/btc/wallet"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCreate_UTXOs(t *testing.T) {
+	segwitAddr, err := wallet.AddressFromPrivateKeyWithType(privateKey2, netchain.TestNet, wallet.P2WPKH)
+	assert.Nil(t, err)
+	script := hex.EncodeToString(addressPkScript(t, segwitAddr))
+	utxos := []addressinfo.UTXO{
+		{TxID: chainhash.HashH([]byte("first")).String(), TxOutIdx: 0, Pbscript: script, Balance: 3e5},
+		{TxID: chainhash.HashH([]byte("second")).String(), TxOutIdx: 2, Pbscript: script, Balance: 4e5},
+	}
+	params := CreateParams{
+		PrivateKey:  privateKey2,
+		AddressType: wallet.P2WPKH,
+		UTXOs:       utxos,
+		Destination: destination1,
+		Amount:      1e5,
+		FeeRate:     5,
+		Fetch: func(address string, net netchain.Net) (addressinfo.Address, error) {
+			t.Fatal("UTXOs are spent without calling the API")
+			return addressinfo.Address{}, nil
+		},
+		Net: netchain.TestNet,
+	}
+
+	t.Run("SpendsExactlyThem", func(t *testing.T) {
+		result, err := CreateWithResult(params)
+		assert.Nil(t, err)
+		tx := decodeTx(t, result.RawTx)
+		assert.EqualValues(t, 2, len(tx.TxIn), "one UTXO would be enough")
+		assert.EqualValues(t, utxos[0].TxID, tx.TxIn[0].PreviousOutPoint.Hash.String())
+		assert.EqualValues(t, 2, tx.TxIn[1].PreviousOutPoint.Index)
+		change := outputTo(t, tx, addressPkScript(t, segwitAddr))
+		assert.EqualValues(t, 7e5-1e5-result.Fee, change.Value)
+		assert.GreaterOrEqual(t, result.Fee, 5*virtualSize(tx))
+		assert.Nil(t, Verify(result.RawTx, utxos))
+	})
+	t.Run("SendAll", func(t *testing.T) {
+		p := params
+		p.SendAll = true
+		rawTx, err := Create(p)
+		assert.Nil(t, err)
+		tx := decodeTx(t, rawTx)
+		assert.EqualValues(t, 2, len(tx.TxIn))
+		assert.EqualValues(t, 1, len(tx.TxOut))
+	})
+	t.Run("Validation", func(t *testing.T) {
+		p := params
+		p.Amount = 7e5
+		_, err := Create(p)
+		assert.True(t, errors.Is(err, ErrInsufficientFunds))
+
+		p = params
+		p.UTXOs = append([]addressinfo.UTXO{}, utxos...)
+		p.UTXOs[1].Pbscript = hex.EncodeToString(addressPkScript(t, destination3))
+		_, err = Create(p)
+		assert.NotNil(t, err, "UTXO of another key")
+
+		p = params
+		p.UTXOs = []addressinfo.UTXO{utxos[0], utxos[0]}
+		_, err = Create(p)
+		assert.NotNil(t, err, "same UTXO twice")
+	})
+}
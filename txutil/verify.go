@@ -0,0 +1,53 @@
+package txutil
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/glossd/btc/addressinfo"
+)
+
+// Verify runs each input of the hex-encoded signed transaction through the script engine
+// with the standard verify flags of the nodes. The prevouts are the UTXOs the inputs spend, in any order.
+// The error matches ErrInvalidSignature with errors.Is if an input doesn't validate.
+func Verify(rawTx string, prevouts []addressinfo.UTXO) error {
+	tx, err := hexDecodeTx(rawTx)
+	if err != nil {
+		return err
+	}
+	fetcher := txscript.NewMultiPrevOutFetcher(nil)
+	for _, u := range prevouts {
+		hash, err := chainhash.NewHashFromStr(u.TxID)
+		if err != nil {
+			return err
+		}
+		pkScript, err := hex.DecodeString(u.Pbscript)
+		if err != nil {
+			return err
+		}
+		fetcher.AddPrevOut(*wire.NewOutPoint(hash, uint32(u.TxOutIdx)), wire.NewTxOut(u.Balance, pkScript))
+	}
+	return verifyTx(tx, fetcher)
+}
+
+func verifyTx(tx *wire.MsgTx, fetcher *txscript.MultiPrevOutFetcher) error {
+	for i, in := range tx.TxIn {
+		if fetcher.FetchPrevOutput(in.PreviousOutPoint) == nil {
+			return fmt.Errorf("no prevout of input %d spending %s", i, in.PreviousOutPoint)
+		}
+	}
+	sigHashes := txscript.NewTxSigHashes(tx, fetcher)
+	for i, in := range tx.TxIn {
+		prevOut := fetcher.FetchPrevOutput(in.PreviousOutPoint)
+		vm, err := txscript.NewEngine(prevOut.PkScript, tx, i, txscript.StandardVerifyFlags, nil, sigHashes, prevOut.Value, fetcher)
+		if err == nil {
+			err = vm.Execute()
+		}
+		if err != nil {
+			return fmt.Errorf("%w, input %d spending %s: %s", ErrInvalidSignature, i, in.PreviousOutPoint, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package txutil
+
+import (
+	"encoding/hex"
+	"errors"
+	"github.com/glossd/btc/addressinfo"
+	"github.com/glossd/btc/netchain"
+	"github.com/glossd/btc/wallet"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	segwitAddr, err := wallet.AddressFromPrivateKeyWithType(privateKey2, netchain.TestNet, wallet.P2WPKH)
+	assert.Nil(t, err)
+	rawTx, err := Create(CreateParams{
+		PrivateKeys: []string{privateKey1, privateKey2},
+		AddressType: wallet.P2WPKH,
+		Destination: destination3,
+		Amount:      addressinfo.MockAddressBalance * 3 / 2,
+		Fetch:       addressinfo.FetchMock,
+		Net:         netchain.TestNet,
+	})
+	assert.Nil(t, err)
+
+	addr1, err := wallet.AddressFromPrivateKeyWithType(privateKey1, netchain.TestNet, wallet.P2WPKH)
+	assert.Nil(t, err)
+	var prevouts []addressinfo.UTXO
+	for _, a := range []string{segwitAddr, addr1} {
+		info, err := addressinfo.FetchMock(a, netchain.TestNet)
+		assert.Nil(t, err)
+		prevouts = append(prevouts, info.UTXOs...)
+	}
+	assert.Nil(t, Verify(rawTx, prevouts))
+
+	// SegWit signatures commit to the amount
+	wrongAmount := append([]addressinfo.UTXO{}, prevouts...)
+	wrongAmount[0].Balance++
+	assert.True(t, errors.Is(Verify(rawTx, wrongAmount), ErrInvalidSignature))
+
+	assert.NotNil(t, Verify(rawTx, prevouts[:1]), "no prevout of an input")
+}
+
+func TestCreate_WrongScriptOfProvider(t *testing.T) {
+	_, err := Create(CreateParams{
+		PrivateKey:  privateKey1,
+		Destination: destination2,
+		Amount:      5e5,
+		Fetch: func(address string, net netchain.Net) (addressinfo.Address, error) {
+			addr, err := addressinfo.FetchMock(address, net)
+			addr.UTXOs[0].Pbscript = hex.EncodeToString(addressPkScript(t, destination3))
+			return addr, err
+		},
+		Net: netchain.TestNet,
+	})
+	assert.True(t, errors.Is(err, ErrInvalidSignature))
+}
@@ -0,0 +1,105 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/glossd/btc/netchain"
+)
+
+// AddressType defines how the public key of a wallet is locked into an address.
+type AddressType string
+
+// P2PKH is the legacy pay-to-pubkey-hash address, e.g. 1... on mainnet, m... or n... on testnet.
+const P2PKH AddressType = "p2pkh"
+
+// P2WPKH is the native SegWit pay-to-witness-pubkey-hash address, e.g. bc1q... on mainnet, tb1q... on testnet.
+const P2WPKH AddressType = "p2wpkh"
+
+// P2SHP2WPKH is the nested SegWit address, P2WPKH wrapped into pay-to-script-hash, e.g. 3... on mainnet, 2... on testnet.
+const P2SHP2WPKH AddressType = "p2sh-p2wpkh"
+
+// P2TR is the Taproot pay-to-taproot address spendable with the key path only, e.g. bc1p... on mainnet, tb1p... on testnet.
+// The output key is the public key tweaked by BIP341 without a script tree.
+const P2TR AddressType = "p2tr"
+
+func (t AddressType) String() string {
+	return string(t)
+}
+
+// addressFromPubKey returns the address of the type, only P2PKH depends on whether the public key is compressed.
+func addressFromPubKey(pub *btcec.PublicKey, compressed bool, t AddressType, net netchain.Net) (btcutil.Address, error) {
+	switch t {
+	case P2PKH, "":
+		if compressed {
+			return btcutil.NewAddressPubKey(pub.SerializeCompressed(), net.GetBtcdNetParams())
+		}
+		return btcutil.NewAddressPubKey(pub.SerializeUncompressed(), net.GetBtcdNetParams())
+	case P2WPKH:
+		return btcutil.NewAddressWitnessPubKeyHash(btcutil.Hash160(pub.SerializeCompressed()), net.GetBtcdNetParams())
+	case P2SHP2WPKH:
+		return btcutil.NewAddressScriptHash(p2wpkhScript(pub), net.GetBtcdNetParams())
+	case P2TR:
+		outputKey := txscript.ComputeTaprootKeyNoScript(pub)
+		return btcutil.NewAddressTaproot(schnorr.SerializePubKey(outputKey), net.GetBtcdNetParams())
+	case P2SH, P2WSH, P2SHP2WSH:
+		return nil, fmt.Errorf("address type '%s' is for multisig, use MultisigAddress", t)
+	default:
+		return nil, fmt.Errorf("address type '%s' is not supported", t)
+	}
+}
+
+// RedeemScript returns the script hashed into the P2SH address of the private key.
+// It's nil for the types which aren't wrapped into P2SH.
+func RedeemScript(privKey string, t AddressType) ([]byte, error) {
+	wif, err := btcutil.DecodeWIF(privKey)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't decode private key")
+	}
+	return redeemScript(wif.PrivKey.PubKey(), t), nil
+}
+
+// RedeemScriptFromPublicKey works as RedeemScript for the hex-encoded public key.
+func RedeemScriptFromPublicKey(pubKey string, t AddressType) ([]byte, error) {
+	pub, err := ParsePublicKey(pubKey)
+	if err != nil {
+		return nil, err
+	}
+	return redeemScript(pub, t), nil
+}
+
+// ParsePublicKey decodes the hex-encoded compressed or uncompressed public key.
+func ParsePublicKey(pubKey string) (*btcec.PublicKey, error) {
+	pub, _, err := parsePublicKey(pubKey)
+	return pub, err
+}
+
+// parsePublicKey works as ParsePublicKey and tells whether the key is compressed.
+func parsePublicKey(pubKey string) (*btcec.PublicKey, bool, error) {
+	pubBytes, err := hex.DecodeString(pubKey)
+	if err != nil {
+		return nil, false, fmt.Errorf("couldn't decode public key")
+	}
+	pub, err := btcec.ParsePubKey(pubBytes)
+	if err != nil {
+		return nil, false, fmt.Errorf("couldn't parse public key: %s", err)
+	}
+	return pub, len(pubBytes) == btcec.PubKeyBytesLenCompressed, nil
+}
+
+func redeemScript(pub *btcec.PublicKey, t AddressType) []byte {
+	switch t {
+	case P2SHP2WPKH:
+		return p2wpkhScript(pub)
+	default:
+		return nil
+	}
+}
+
+// p2wpkhScript returns the witness program: OP_0 <20-byte hash of the compressed public key>.
+func p2wpkhScript(pub *btcec.PublicKey) []byte {
+	return append([]byte{txscript.OP_0, txscript.OP_DATA_20}, btcutil.Hash160(pub.SerializeCompressed())...)
+}
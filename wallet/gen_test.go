@@ -2,9 +2,11 @@ package wallet
 
 import (
 	"encoding/hex"
-	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/chaincfg"
-	"github.com/btcsuite/btcutil"
+	"github.com/glossd/btc/netchain"
+	"github.com/stretchr/testify/assert"
 	"log"
 	"testing"
 )
@@ -15,7 +17,7 @@ func TestWalletCompatibility(t *testing.T) {
 	if err != nil {
 		log.Fatal(err)
 	}
-	priv, _ := btcec.PrivKeyFromBytes(btcec.S256(), decodeString)
+	priv, _ := btcec.PrivKeyFromBytes(decodeString)
 
 	wif, err := btcutil.NewWIF(priv, &chaincfg.TestNet3Params, false)
 	if err != nil {
@@ -26,8 +28,6 @@ func TestWalletCompatibility(t *testing.T) {
 		log.Fatal("wif not right")
 	}
 
-
-
 	decodeWIF, err := btcutil.DecodeWIF(realWif)
 	if err != nil {
 		log.Fatal(err)
@@ -38,3 +38,29 @@ func TestWalletCompatibility(t *testing.T) {
 		log.Fatal("wrong hex priv")
 	}
 }
+
+func TestNew(t *testing.T) {
+	priv, addr := New(netchain.TestNet)
+	wif, err := btcutil.DecodeWIF(priv)
+	assert.Nil(t, err)
+	assert.True(t, wif.CompressPubKey)
+	gotAddr, err := AddressFromPrivateKey(priv, netchain.TestNet)
+	assert.Nil(t, err)
+	assert.EqualValues(t, addr, gotAddr)
+
+	priv, addr = NewUncompressed(netchain.TestNet)
+	wif, err = btcutil.DecodeWIF(priv)
+	assert.Nil(t, err)
+	assert.False(t, wif.CompressPubKey)
+	gotAddr, err = AddressFromPrivateKey(priv, netchain.TestNet)
+	assert.Nil(t, err)
+	assert.EqualValues(t, addr, gotAddr)
+}
+
+func TestNewWithType(t *testing.T) {
+	priv, addr := NewWithType(netchain.TestNet, P2WPKH)
+	gotAddr, err := AddressFromPrivateKeyWithType(priv, netchain.TestNet, P2WPKH)
+	assert.Nil(t, err)
+	assert.EqualValues(t, addr, gotAddr)
+	assert.True(t, IsAddressValid(addr, netchain.TestNet))
+}
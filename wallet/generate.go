@@ -1,19 +1,35 @@
 package wallet
 
 import (
-	"github.com/btcsuite/btcd/btcec"
-	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
 	"github.com/glossd/btc/netchain"
 	"log"
 )
 
+// New generates a compressed private key and its P2PKH address.
 func New(net netchain.Net) (privateKeyWif, bitcoinAddress string) {
+	return NewWithType(net, P2PKH)
+}
+
+// NewWithType generates a compressed private key and its address of the specified type.
+func NewWithType(net netchain.Net, t AddressType) (privateKeyWif, bitcoinAddress string) {
+	return newWallet(net, t, true)
+}
+
+// NewUncompressed generates a legacy uncompressed private key and its P2PKH address,
+// e.g. for the software which doesn't support compressed keys. Its P2PKH transactions are larger.
+func NewUncompressed(net netchain.Net) (privateKeyWif, bitcoinAddress string) {
+	return newWallet(net, P2PKH, false)
+}
+
+func newWallet(net netchain.Net, t AddressType, compressed bool) (privateKeyWif, bitcoinAddress string) {
 	// errors shouldn't happen
-	priv, err := btcec.NewPrivateKey(btcec.S256())
+	priv, err := btcec.NewPrivateKey()
 	check(err)
-	wif, err := btcutil.NewWIF(priv, net.GetBtcdNetParams(), false)
+	wif, err := btcutil.NewWIF(priv, net.GetBtcdNetParams(), compressed)
 	check(err)
-	addr, err := btcutil.NewAddressPubKey(priv.PubKey().SerializeUncompressed(), net.GetBtcdNetParams())
+	addr, err := addressFromPubKey(priv.PubKey(), compressed, t, net)
 	check(err)
 	return wif.String(), addr.EncodeAddress()
 }
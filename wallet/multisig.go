@@ -0,0 +1,118 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/glossd/btc/netchain"
+	"sort"
+)
+
+// P2SH is the legacy pay-to-script-hash address of a multisig script, e.g. 3... on mainnet, 2... on testnet.
+const P2SH AddressType = "p2sh"
+
+// P2WSH is the native SegWit pay-to-witness-script-hash address of a multisig script, e.g. bc1q... on mainnet, tb1q... on testnet.
+// It's longer than P2WPKH, the hash of the script takes 32 bytes.
+const P2WSH AddressType = "p2wsh"
+
+// P2SHP2WSH is the nested SegWit address of a multisig script, P2WSH wrapped into pay-to-script-hash.
+const P2SHP2WSH AddressType = "p2sh-p2wsh"
+
+// The most public keys fitting the 520 bytes of the P2SH redeem script.
+const maxP2SHMultisigKeys = 15
+
+// The most public keys OP_CHECKMULTISIG of the witness script accepts, the standard limit of P2WSH.
+const maxWitnessMultisigKeys = 20
+
+// maxMultisigKeys returns the most public keys the multisig address of the type can have.
+func maxMultisigKeys(t AddressType) int {
+	if t == P2SH {
+		return maxP2SHMultisigKeys
+	}
+	return maxWitnessMultisigKeys
+}
+
+// MultisigScript returns the script requiring the signatures of the required number of the hex-encoded public keys.
+// The keys are sorted by BIP67, so the co-signers get the same script whatever order they list the keys in.
+// It takes up to 20 keys, but P2SH addresses fit only 15 of them.
+func MultisigScript(required int, pubKeys []string) ([]byte, error) {
+	return multisigScriptOfType(required, pubKeys, P2WSH)
+}
+
+func multisigScriptOfType(required int, pubKeys []string, t AddressType) ([]byte, error) {
+	if max := maxMultisigKeys(t); len(pubKeys) == 0 || len(pubKeys) > max {
+		return nil, fmt.Errorf("multisig of %s must have from 1 to %d public keys, got %d", t, max, len(pubKeys))
+	}
+	if required < 1 || required > len(pubKeys) {
+		return nil, fmt.Errorf("multisig must require from 1 to %d signatures, got %d", len(pubKeys), required)
+	}
+	var keys [][]byte
+	for _, pubKey := range pubKeys {
+		pub, err := ParsePublicKey(pubKey)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, pub.SerializeCompressed())
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i], keys[j]) < 0
+	})
+
+	builder := txscript.NewScriptBuilder().AddInt64(int64(required))
+	for i, key := range keys {
+		if i > 0 && bytes.Equal(keys[i-1], key) {
+			return nil, fmt.Errorf("multisig has duplicate public key %x", key)
+		}
+		builder.AddData(key)
+	}
+	return builder.AddInt64(int64(len(keys))).AddOp(txscript.OP_CHECKMULTISIG).Script()
+}
+
+// MultisigAddress returns the address of the MultisigScript, the type must be P2SH, P2WSH or P2SHP2WSH.
+func MultisigAddress(required int, pubKeys []string, net netchain.Net, t AddressType) (string, error) {
+	script, err := multisigScriptOfType(required, pubKeys, t)
+	if err != nil {
+		return "", err
+	}
+	var addr btcutil.Address
+	switch t {
+	case P2SH:
+		addr, err = btcutil.NewAddressScriptHash(script, net.GetBtcdNetParams())
+	case P2WSH:
+		hash := sha256.Sum256(script)
+		addr, err = btcutil.NewAddressWitnessScriptHash(hash[:], net.GetBtcdNetParams())
+	case P2SHP2WSH:
+		addr, err = btcutil.NewAddressScriptHash(p2wshScript(script), net.GetBtcdNetParams())
+	default:
+		return "", fmt.Errorf("address type '%s' is not supported for multisig", t)
+	}
+	if err != nil {
+		return "", fmt.Errorf("couldn't extract multisig address: %s", err)
+	}
+	return addr.EncodeAddress(), nil
+}
+
+// MultisigRedeemScript returns the script hashed into the P2SH address of the multisig.
+// It's the MultisigScript itself for P2SH, the witness program for P2SHP2WSH and nil for P2WSH.
+func MultisigRedeemScript(required int, pubKeys []string, t AddressType) ([]byte, error) {
+	script, err := multisigScriptOfType(required, pubKeys, t)
+	if err != nil {
+		return nil, err
+	}
+	switch t {
+	case P2SH:
+		return script, nil
+	case P2SHP2WSH:
+		return p2wshScript(script), nil
+	default:
+		return nil, nil
+	}
+}
+
+// p2wshScript returns the witness program: OP_0 <32-byte hash of the witness script>.
+func p2wshScript(witnessScript []byte) []byte {
+	hash := sha256.Sum256(witnessScript)
+	return append([]byte{txscript.OP_0, txscript.OP_DATA_32}, hash[:]...)
+}
@@ -0,0 +1,84 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/glossd/btc/netchain"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// the first test vector of BIP67
+var bip67PubKeys = []string{
+	"02ff12471208c14bd580709cb2358d98975247d8765f92bc25eab3b2763ed605f8",
+	"02fe6f0a5a297eb38c391581c4413e084773ea23954d93f7753db7dc0adc188b2f",
+}
+
+func TestMultisigScript(t *testing.T) {
+	script, err := MultisigScript(2, bip67PubKeys)
+	assert.Nil(t, err)
+	assert.EqualValues(t, "522102fe6f0a5a297eb38c391581c4413e084773ea23954d93f7753db7dc0adc188b2f2102ff12471208c14bd580709cb2358d98975247d8765f92bc25eab3b2763ed605f852ae", hex.EncodeToString(script))
+
+	reversed, err := MultisigScript(2, []string{bip67PubKeys[1], bip67PubKeys[0]})
+	assert.Nil(t, err)
+	assert.EqualValues(t, script, reversed)
+
+	_, err = MultisigScript(3, bip67PubKeys)
+	assert.NotNil(t, err)
+	_, err = MultisigScript(0, bip67PubKeys)
+	assert.NotNil(t, err)
+	_, err = MultisigScript(1, []string{bip67PubKeys[0], bip67PubKeys[0]})
+	assert.NotNil(t, err)
+}
+
+func TestMultisigAddress(t *testing.T) {
+	address, err := MultisigAddress(2, bip67PubKeys, netchain.MainNet, P2SH)
+	assert.Nil(t, err)
+	assert.EqualValues(t, "39bgKC7RFbpoCRbtD5KEdkYKtNyhpsNa3Z", address)
+
+	address, err = MultisigAddress(2, bip67PubKeys, netchain.MainNet, P2WSH)
+	assert.Nil(t, err)
+	assert.Len(t, address, 62)
+	assert.True(t, IsAddressValid(address, netchain.MainNet))
+
+	address, err = MultisigAddress(2, bip67PubKeys, netchain.TestNet, P2SHP2WSH)
+	assert.Nil(t, err)
+	assert.EqualValues(t, '2', address[0])
+
+	_, err = MultisigAddress(2, bip67PubKeys, netchain.MainNet, P2WPKH)
+	assert.NotNil(t, err)
+}
+
+func TestMultisigAddress_MaxKeys(t *testing.T) {
+	var pubKeys []string
+	for i := 0; i < 21; i++ {
+		priv, err := btcec.NewPrivateKey()
+		assert.Nil(t, err)
+		pubKeys = append(pubKeys, hex.EncodeToString(priv.PubKey().SerializeCompressed()))
+	}
+	for addrType, max := range map[AddressType]int{P2SH: 15, P2WSH: 20, P2SHP2WSH: 20} {
+		_, err := MultisigAddress(1, pubKeys[:max], netchain.MainNet, addrType)
+		assert.Nil(t, err, addrType)
+		_, err = MultisigAddress(1, pubKeys[:max+1], netchain.MainNet, addrType)
+		assert.NotNil(t, err, addrType)
+		_, err = MultisigRedeemScript(1, pubKeys[:max+1], addrType)
+		assert.NotNil(t, err, addrType)
+	}
+}
+
+func TestMultisigRedeemScript(t *testing.T) {
+	script, err := MultisigScript(2, bip67PubKeys)
+	assert.Nil(t, err)
+
+	redeemScript, err := MultisigRedeemScript(2, bip67PubKeys, P2SH)
+	assert.Nil(t, err)
+	assert.EqualValues(t, script, redeemScript)
+
+	redeemScript, err = MultisigRedeemScript(2, bip67PubKeys, P2SHP2WSH)
+	assert.Nil(t, err)
+	assert.EqualValues(t, p2wshScript(script), redeemScript)
+
+	redeemScript, err = MultisigRedeemScript(2, bip67PubKeys, P2WSH)
+	assert.Nil(t, err)
+	assert.Nil(t, redeemScript)
+}
@@ -6,15 +6,15 @@ import (
 	"log"
 	"testing"
 
-	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/base58"
 	"github.com/btcsuite/btcd/chaincfg"
-	"github.com/btcsuite/btcutil"
-	"github.com/btcsuite/btcutil/base58"
 )
 
 func TestPrintFullInfo(t *testing.T) {
 	netParams := &chaincfg.TestNet3Params
-	priv, err := btcec.NewPrivateKey(btcec.S256())
+	priv, err := btcec.NewPrivateKey()
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -62,4 +62,4 @@ func TestPrintFullInfo(t *testing.T) {
 	encCmpAddr := cmpAddr.EncodeAddress()
 	fmt.Printf("address [base58] (uncompressed):\n%s\n\n", encUncAddr) // 16385kYLPqkczsyhJirzjunz27bTpqJrNm
 	fmt.Printf("address [base58] (compressed):\n%s\n\n", encCmpAddr)   // 15xQjUYRuk59ijmbCkSFTiP7zYWD4NVN1G
-}
\ No newline at end of file
+}
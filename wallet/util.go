@@ -2,18 +2,38 @@ package wallet
 
 import (
 	"fmt"
-	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcd/btcutil"
 	"github.com/glossd/btc/netchain"
 )
 
 func AddressFromPrivateKey(privKey string, net netchain.Net) (string, error) {
+	return AddressFromPrivateKeyWithType(privKey, net, P2PKH)
+}
+
+// AddressFromPrivateKeyWithType returns the address of the specified type which the private key can spend from.
+// The P2PKH address of the compressed WIF hashes the compressed public key, of the uncompressed WIF the uncompressed one.
+func AddressFromPrivateKeyWithType(privKey string, net netchain.Net, t AddressType) (string, error) {
 	wif, err := btcutil.DecodeWIF(privKey)
 	if err != nil {
 		return "", fmt.Errorf("couldn't decode private key")
 	}
-	addr, err := btcutil.NewAddressPubKey(wif.PrivKey.PubKey().SerializeUncompressed(), net.GetBtcdNetParams())
+	addr, err := addressFromPubKey(wif.PrivKey.PubKey(), wif.CompressPubKey, t, net)
+	if err != nil {
+		return "", fmt.Errorf("couldn't extract address from private key: %s", err)
+	}
+	return addr.EncodeAddress(), nil
+}
+
+// AddressFromPublicKeyWithType returns the address of the specified type for the hex-encoded public key.
+// The P2PKH address hashes the public key in the format it's encoded in.
+func AddressFromPublicKeyWithType(pubKey string, net netchain.Net, t AddressType) (string, error) {
+	pub, compressed, err := parsePublicKey(pubKey)
+	if err != nil {
+		return "", err
+	}
+	addr, err := addressFromPubKey(pub, compressed, t, net)
 	if err != nil {
-		return "", fmt.Errorf("couldn't extract address from private key")
+		return "", fmt.Errorf("couldn't extract address from public key: %s", err)
 	}
 	return addr.EncodeAddress(), nil
 }
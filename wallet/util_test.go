@@ -1,6 +1,7 @@
 package wallet
 
 import (
+	"encoding/hex"
 	"github.com/glossd/btc/netchain"
 	"github.com/stretchr/testify/assert"
 	"testing"
@@ -10,6 +11,56 @@ func TestAddressFromPrivateKey(t *testing.T) {
 	address, err := AddressFromPrivateKey("932u6Q4xEC9UYRb3rS2BWrSpSPEt5KaU8NNP7EWy7zSkWmfBiGe", netchain.TestNet)
 	assert.Nil(t, err)
 	assert.EqualValues(t, "mgFv6afUVhrdd3D6mY2iyWzHVk5b64qTok", address)
+
+	// the compressed WIF
+	address, err = AddressFromPrivateKey("cMvRbsVJKjRkZTV7tosWEYEu1x8tQcnLEbC64RiKwPeeEz29j8QZ", netchain.TestNet)
+	assert.Nil(t, err)
+	assert.EqualValues(t, "mw9ASiaxfo2HZjEVV6u5x7x7DoZzu6ykHT", address)
+}
+
+func TestAddressFromPrivateKeyWithType(t *testing.T) {
+	address, err := AddressFromPrivateKeyWithType("cMvRbsVJKjRkZTV7tosWEYEu1x8tQcnLEbC64RiKwPeeEz29j8QZ", netchain.TestNet, P2WPKH)
+	assert.Nil(t, err)
+	assert.EqualValues(t, "tb1q4d3spna3y8ael84t08f25mh0qe6qz3eg2ccll4", address)
+
+	address, err = AddressFromPrivateKeyWithType("cMvRbsVJKjRkZTV7tosWEYEu1x8tQcnLEbC64RiKwPeeEz29j8QZ", netchain.TestNet, P2SHP2WPKH)
+	assert.Nil(t, err)
+	assert.EqualValues(t, "2N6Ai2Ybmssa8p6MNUhjEpGTFWx2iL1cM2q", address)
+
+	address, err = AddressFromPrivateKeyWithType("cMvRbsVJKjRkZTV7tosWEYEu1x8tQcnLEbC64RiKwPeeEz29j8QZ", netchain.TestNet, P2TR)
+	assert.Nil(t, err)
+	assert.EqualValues(t, "tb1pm7zdzckvk2j9mtnvsusvdsum0uk55xwnk6zh4399gsfstzd9scnqupvcv9", address)
+
+	_, err = AddressFromPrivateKeyWithType("cMvRbsVJKjRkZTV7tosWEYEu1x8tQcnLEbC64RiKwPeeEz29j8QZ", netchain.TestNet, "p2unknown")
+	assert.NotNil(t, err)
+}
+
+func TestAddressFromPublicKeyWithType(t *testing.T) {
+	pubKey := "035f57130789c90d424471f0568e203c00ee21e7dcc4fe90798c9eab9db34ad483"
+	address, err := AddressFromPublicKeyWithType(pubKey, netchain.TestNet, P2WPKH)
+	assert.Nil(t, err)
+	assert.EqualValues(t, "tb1q4d3spna3y8ael84t08f25mh0qe6qz3eg2ccll4", address)
+
+	address, err = AddressFromPublicKeyWithType(pubKey, netchain.TestNet, P2PKH)
+	assert.Nil(t, err)
+	assert.EqualValues(t, "mw9ASiaxfo2HZjEVV6u5x7x7DoZzu6ykHT", address)
+
+	script, err := RedeemScriptFromPublicKey(pubKey, P2SHP2WPKH)
+	assert.Nil(t, err)
+	assert.EqualValues(t, "0014ab6300cfb121fb9f9eab79d2aa6eef0674014728", hex.EncodeToString(script))
+
+	_, err = AddressFromPublicKeyWithType("035f57", netchain.TestNet, P2WPKH)
+	assert.NotNil(t, err)
+}
+
+func TestRedeemScript(t *testing.T) {
+	script, err := RedeemScript("cMvRbsVJKjRkZTV7tosWEYEu1x8tQcnLEbC64RiKwPeeEz29j8QZ", P2SHP2WPKH)
+	assert.Nil(t, err)
+	assert.EqualValues(t, "0014ab6300cfb121fb9f9eab79d2aa6eef0674014728", hex.EncodeToString(script))
+
+	script, err = RedeemScript("cMvRbsVJKjRkZTV7tosWEYEu1x8tQcnLEbC64RiKwPeeEz29j8QZ", P2WPKH)
+	assert.Nil(t, err)
+	assert.Nil(t, script)
 }
 
 func TestIsAddressValid(t *testing.T) {
@@ -0,0 +1,490 @@
+// Package withdrawal batches the payouts into periodic transactions, so that hundreds of withdrawals
+// share the fee and the inputs of a few transactions instead of one transaction each.
+package withdrawal
+
+import (
+	"fmt"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/glossd/btc/addressinfo"
+	"github.com/glossd/btc/netchain"
+	"github.com/glossd/btc/txutil"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultInterval is how often Run sends the queued withdrawals.
+const DefaultInterval = 10 * time.Minute
+
+// DefaultMaxSize is the number of withdrawals which makes Run send them before the Interval.
+const DefaultMaxSize = 100
+
+// Request is a withdrawal to send.
+type Request struct {
+	// Unique key of the withdrawal, the request with the key queued or sent before is ignored.
+	Key string
+	// Bitcoin address of the receiver.
+	Address string
+	// Measured in satoshi.
+	Amount int64
+}
+
+// Batch is the transaction paying out the requests.
+type Batch struct {
+	TxID string
+	// Hex-encoded signed transaction.
+	RawTx    string
+	Requests []Request
+	// In satoshi, what the miners get.
+	Fee int64
+	// False until Broadcast accepts the transaction. The unsent batch is broadcast again as it is,
+	// another transaction paying the same requests could pay them twice. Use Queue.Drop or Queue.Replace
+	// if the nodes keep rejecting it.
+	Sent bool
+}
+
+// The errors of the nodes and the providers for a transaction they already have, in lower case.
+var alreadyBroadcastErrors = []string{
+	"already exists",
+	"txn-already-in-mempool",
+	"txn-already-known",
+	"already in block chain",
+	"outputs already in utxo set",
+}
+
+type Params struct {
+	// Keys, fees and the net of the transactions e.g. PrivateKeys with FeeRate and ChangeAddress.
+	// Its Destinations are the queued requests, so Destination, Amount, Destinations and SendAll must be empty.
+	CreateParams txutil.CreateParams
+	// How often Run sends the queued withdrawals, defaults to DefaultInterval.
+	Interval time.Duration
+	// The most withdrawals in one transaction, Run sends them as soon as that many are queued. defaults to DefaultMaxSize.
+	MaxSize int
+	// Remembers which transaction paid out each request, defaults to NewMemoryStore().
+	Store Store
+	// Must return an error only if the transaction wasn't accepted, the same transaction is broadcast again then.
+	// The error of a transaction already in the mempool or mined counts as accepted. defaults to txutil.Broadcast.
+	Broadcast func(rawTx string, net netchain.Net) (string, error)
+	// Receives the errors of the batches sent by Run.
+	OnError func(error)
+}
+
+// Queue collects the withdrawals and sends them in batches. It's safe for concurrent use.
+type Queue struct {
+	params Params
+
+	mu      sync.Mutex
+	pending []Request
+	// keys of the pending and the sending requests
+	queued map[string]bool
+	full   chan struct{}
+
+	flushMu sync.Mutex
+	// saved batches whose broadcast failed, guarded by flushMu
+	unsent []Batch
+	// outpoints spent by the saved batches to the TxID of the batch, guarded by flushMu.
+	// The provider may list them until it sees the transaction, the next batch mustn't spend them again.
+	spent map[string]string
+}
+
+// New creates the queue, the unsent batches of the Store are broadcast again first.
+func New(params Params) (*Queue, error) {
+	params, err := checkParams(params)
+	if err != nil {
+		return nil, err
+	}
+	unsent, err := params.Store.Unsent()
+	if err != nil {
+		return nil, err
+	}
+	q := &Queue{params: params, queued: make(map[string]bool), full: make(chan struct{}, 1), unsent: unsent, spent: make(map[string]string)}
+	for _, batch := range unsent {
+		decoded, err := txutil.Decode(batch.RawTx, params.CreateParams.Net)
+		if err != nil {
+			return nil, err
+		}
+		q.markSpent(batch.TxID, decoded)
+	}
+	return q, nil
+}
+
+func checkParams(p Params) (Params, error) {
+	cp := p.CreateParams
+	if cp.Destination != "" || cp.Amount != 0 || len(cp.Destinations) > 0 || cp.SendAll {
+		return Params{}, fmt.Errorf("destinations of CreateParams must be empty, they are the queued requests")
+	}
+	if p.CreateParams.Net == "" {
+		p.CreateParams.Net = netchain.MainNet
+	}
+	if p.CreateParams.Net != netchain.MainNet && p.CreateParams.Net != netchain.TestNet {
+		return Params{}, fmt.Errorf("net chain '%s' is not supported", p.CreateParams.Net)
+	}
+	if p.CreateParams.DustRelayFee == 0 {
+		p.CreateParams.DustRelayFee = txutil.DefaultDustRelayFee
+	}
+	if p.CreateParams.Fetch == nil {
+		p.CreateParams.Fetch = addressinfo.FetchFromBlockcypher
+	}
+	if p.Interval == 0 {
+		p.Interval = DefaultInterval
+	}
+	if p.Interval < 0 {
+		return Params{}, fmt.Errorf("Interval can't be negative")
+	}
+	if p.MaxSize == 0 {
+		p.MaxSize = DefaultMaxSize
+	}
+	if p.MaxSize < 0 {
+		return Params{}, fmt.Errorf("MaxSize can't be negative")
+	}
+	if p.Store == nil {
+		p.Store = NewMemoryStore()
+	}
+	if p.Broadcast == nil {
+		p.Broadcast = txutil.Broadcast
+	}
+	return p, nil
+}
+
+// Enqueue adds the withdrawal to the next batch. The request with the key of a queued or sent one is ignored.
+func (q *Queue) Enqueue(r Request) error {
+	if r.Key == "" {
+		return fmt.Errorf("request must have the Key")
+	}
+	if err := checkRequest(r, q.params.CreateParams); err != nil {
+		return err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.queued[r.Key] {
+		return nil
+	}
+	// looked up under the lock, Flush forgets the queued keys only after the Store has them
+	txID, err := q.params.Store.TxIDOf(r.Key)
+	if err != nil {
+		return err
+	}
+	if txID != "" {
+		return nil
+	}
+	q.queued[r.Key] = true
+	q.pending = append(q.pending, r)
+	if len(q.pending) >= q.params.MaxSize {
+		select {
+		case q.full <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// checkRequest rejects the withdrawals which would fail every batch they are in.
+func checkRequest(r Request, cp txutil.CreateParams) error {
+	pkScript, err := requestScript(r, cp.Net)
+	if err != nil {
+		return err
+	}
+	if limit := txutil.DustLimit(pkScript, cp.DustRelayFee); r.Amount < limit {
+		return fmt.Errorf("%w, withdrawal %s of %d satoshi, can't be less than %d", txutil.ErrDustOutput, r.Key, r.Amount, limit)
+	}
+	return nil
+}
+
+func requestScript(r Request, net netchain.Net) ([]byte, error) {
+	addr, err := btcutil.DecodeAddress(r.Address, net.GetBtcdNetParams())
+	if err != nil {
+		return nil, &txutil.InvalidAddressError{Address: r.Address, Net: net, Err: err}
+	}
+	if !addr.IsForNet(net.GetBtcdNetParams()) {
+		return nil, &txutil.InvalidAddressError{Address: r.Address, Net: net, Err: fmt.Errorf("address is of another net")}
+	}
+	return txscript.PayToAddrScript(addr)
+}
+
+// Pending returns the number of the withdrawals waiting for the next batch.
+func (q *Queue) Pending() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+// Flush sends up to MaxSize of the queued withdrawals in one transaction.
+// The transaction is saved in the Store before it's broadcast, if it can't be created or saved the requests are queued again.
+// If the broadcast fails, the Batch is returned with the error and the next Flush broadcasts the same transaction
+// before any new one. The empty Batch is returned if nothing is queued.
+func (q *Queue) Flush() (Batch, error) {
+	q.flushMu.Lock()
+	defer q.flushMu.Unlock()
+
+	if len(q.unsent) > 0 {
+		batch, err := q.broadcast(q.unsent[0])
+		if batch.Sent {
+			q.unsent = q.unsent[1:]
+		}
+		return batch, err
+	}
+
+	q.mu.Lock()
+	n := len(q.pending)
+	if n > q.params.MaxSize {
+		n = q.params.MaxSize
+	}
+	requests := q.pending[:n:n]
+	q.pending = q.pending[n:]
+	q.mu.Unlock()
+	if len(requests) == 0 {
+		return Batch{}, nil
+	}
+
+	batch, err := q.save(requests)
+	q.mu.Lock()
+	if err != nil {
+		// the failed requests go first, so that they are sent before the newer ones
+		q.pending = append(requests, q.pending...)
+		q.mu.Unlock()
+		return Batch{}, err
+	}
+	// the Store ignores them from now on
+	for _, r := range requests {
+		delete(q.queued, r.Key)
+	}
+	q.mu.Unlock()
+
+	batch, err = q.broadcast(batch)
+	if !batch.Sent {
+		q.unsent = append(q.unsent, batch)
+	}
+	return batch, err
+}
+
+// save creates the transaction paying out the requests and saves it in the Store.
+// Once saved, the requests are paid only by this transaction.
+func (q *Queue) save(requests []Request) (Batch, error) {
+	params := q.params.CreateParams
+	if len(params.UTXOs) > 0 {
+		params.UTXOs = q.unspent(params.UTXOs)
+		if len(params.UTXOs) == 0 {
+			return Batch{}, fmt.Errorf("UTXOs of CreateParams are spent by the saved batches")
+		}
+	} else {
+		fetch := params.Fetch
+		params.Fetch = func(address string, net netchain.Net) (addressinfo.Address, error) {
+			addr, err := fetch(address, net)
+			if err != nil {
+				return addressinfo.Address{}, err
+			}
+			utxos := q.unspent(addr.UTXOs)
+			addr.UTXOs = utxos
+			addr.Balance = 0
+			for _, u := range utxos {
+				addr.Balance += u.Balance
+			}
+			return addr, nil
+		}
+	}
+	for _, r := range requests {
+		params.Destinations = append(params.Destinations, txutil.Destination{Address: r.Address, Amount: r.Amount})
+	}
+	result, err := txutil.CreateWithResult(params)
+	if err != nil {
+		return Batch{}, fmt.Errorf("couldn't create the batch of %d withdrawals: %w", len(requests), err)
+	}
+	decoded, err := txutil.Decode(result.RawTx, params.Net)
+	if err != nil {
+		return Batch{}, err
+	}
+	batch := Batch{TxID: decoded.TxID, RawTx: result.RawTx, Requests: requests, Fee: result.Fee}
+	if err := q.params.Store.Save(batch); err != nil {
+		return Batch{}, fmt.Errorf("couldn't save the batch of %d withdrawals: %w", len(requests), err)
+	}
+	q.markSpent(batch.TxID, decoded)
+	return batch, nil
+}
+
+// unspent leaves out the UTXOs spent by the saved batches.
+func (q *Queue) unspent(utxos []addressinfo.UTXO) []addressinfo.UTXO {
+	var result []addressinfo.UTXO
+	for _, u := range utxos {
+		if _, ok := q.spent[outPoint(u.TxID, u.TxOutIdx)]; !ok {
+			result = append(result, u)
+		}
+	}
+	return result
+}
+
+// markSpent remembers the outpoints spent by the transaction of the batch.
+func (q *Queue) markSpent(txID string, decoded txutil.DecodedTx) {
+	for _, in := range decoded.Inputs {
+		q.spent[outPoint(in.TxID, in.TxOutIdx)] = txID
+	}
+}
+
+func outPoint(txID string, idx int) string {
+	return fmt.Sprintf("%s:%d", txID, idx)
+}
+
+// broadcast sends the saved transaction of the batch and saves it again as Sent.
+func (q *Queue) broadcast(batch Batch) (Batch, error) {
+	if _, err := q.params.Broadcast(batch.RawTx, q.params.CreateParams.Net); err != nil && !alreadyBroadcast(err) {
+		return batch, fmt.Errorf("couldn't broadcast transaction %s of %d withdrawals, it's broadcast again on the next Flush: %w", batch.TxID, len(batch.Requests), err)
+	}
+	batch.Sent = true
+	if err := q.params.Store.Save(batch); err != nil {
+		return batch, fmt.Errorf("withdrawals of transaction %s are sent but not marked as sent: %w", batch.TxID, err)
+	}
+	return batch, nil
+}
+
+func alreadyBroadcast(err error) bool {
+	msg := strings.ToLower(err.Error())
+	for _, known := range alreadyBroadcastErrors {
+		if strings.Contains(msg, known) {
+			return true
+		}
+	}
+	return false
+}
+
+// Unsent returns the saved batches which Flush broadcasts again before sending any new one.
+func (q *Queue) Unsent() []Batch {
+	q.flushMu.Lock()
+	defer q.flushMu.Unlock()
+	return append([]Batch(nil), q.unsent...)
+}
+
+// Drop forgets the unsent batch which the nodes keep rejecting, its requests are queued again before the newer ones.
+// Only drop the transaction which can't be mined, otherwise the requests are paid twice.
+func (q *Queue) Drop(txID string) error {
+	q.flushMu.Lock()
+	defer q.flushMu.Unlock()
+	i := q.unsentIndex(txID)
+	if i == -1 {
+		return fmt.Errorf("transaction %s isn't an unsent batch", txID)
+	}
+	batch := q.unsent[i]
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err := q.params.Store.Delete(txID); err != nil {
+		return fmt.Errorf("couldn't delete the batch of transaction %s: %w", txID, err)
+	}
+	q.unsent = append(q.unsent[:i:i], q.unsent[i+1:]...)
+	for op, spentBy := range q.spent {
+		if spentBy == txID {
+			delete(q.spent, op)
+		}
+	}
+	for _, r := range batch.Requests {
+		q.queued[r.Key] = true
+	}
+	q.pending = append(append([]Request(nil), batch.Requests...), q.pending...)
+	return nil
+}
+
+// Replace pays the requests of the batch with another transaction e.g. made by txutil.BumpFee from the RawTx of the batch.
+// The replacement must pay every request and spend an input of the batch, so that only one of them is mined.
+// It's saved in place of the batch and broadcast, if the broadcast fails Flush broadcasts it again.
+func (q *Queue) Replace(batch Batch, rawTx string) (Batch, error) {
+	q.flushMu.Lock()
+	defer q.flushMu.Unlock()
+	net := q.params.CreateParams.Net
+	old, err := txutil.Decode(batch.RawTx, net)
+	if err != nil {
+		return Batch{}, err
+	}
+	decoded, err := txutil.DecodeWithFee(rawTx, net, q.params.CreateParams.FetchRawTx)
+	if err != nil {
+		return Batch{}, err
+	}
+	if err := checkReplacement(old, decoded, batch.Requests, net); err != nil {
+		return Batch{}, err
+	}
+	replacement := Batch{TxID: decoded.TxID, RawTx: rawTx, Requests: batch.Requests, Fee: decoded.Fee}
+	if err := q.params.Store.Save(replacement); err != nil {
+		return Batch{}, fmt.Errorf("couldn't save the replacement of transaction %s: %w", batch.TxID, err)
+	}
+	if err := q.params.Store.Delete(batch.TxID); err != nil {
+		return Batch{}, fmt.Errorf("couldn't delete the batch of transaction %s: %w", batch.TxID, err)
+	}
+	q.markSpent(replacement.TxID, decoded)
+	i := q.unsentIndex(batch.TxID)
+	if i != -1 {
+		q.unsent = append(q.unsent[:i:i], q.unsent[i+1:]...)
+	}
+
+	replacement, err = q.broadcast(replacement)
+	if !replacement.Sent {
+		if i == -1 || i > len(q.unsent) {
+			i = len(q.unsent)
+		}
+		q.unsent = append(q.unsent[:i:i], append([]Batch{replacement}, q.unsent[i:]...)...)
+	}
+	return replacement, err
+}
+
+// checkReplacement makes sure the replacement conflicts with the original and pays the same requests.
+func checkReplacement(orig, replacement txutil.DecodedTx, requests []Request, net netchain.Net) error {
+	inputs := make(map[string]bool)
+	for _, in := range orig.Inputs {
+		inputs[outPoint(in.TxID, in.TxOutIdx)] = true
+	}
+	var conflicts bool
+	for _, in := range replacement.Inputs {
+		if inputs[outPoint(in.TxID, in.TxOutIdx)] {
+			conflicts = true
+			break
+		}
+	}
+	if !conflicts {
+		return fmt.Errorf("replacement %s doesn't spend any input of transaction %s", replacement.TxID, orig.TxID)
+	}
+	paid := make(map[string]int)
+	for _, out := range replacement.Outputs {
+		paid[fmt.Sprintf("%s:%d", out.PkScript, out.Value)]++
+	}
+	for _, r := range requests {
+		pkScript, err := requestScript(r, net)
+		if err != nil {
+			return err
+		}
+		key := fmt.Sprintf("%x:%d", pkScript, r.Amount)
+		if paid[key] == 0 {
+			return fmt.Errorf("replacement %s doesn't pay withdrawal %s", replacement.TxID, r.Key)
+		}
+		paid[key]--
+	}
+	return nil
+}
+
+func (q *Queue) unsentIndex(txID string) int {
+	for i, b := range q.unsent {
+		if b.TxID == txID {
+			return i
+		}
+	}
+	return -1
+}
+
+// Run sends the queued withdrawals every Interval or as soon as MaxSize of them are queued, until stop is closed.
+func (q *Queue) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(q.params.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		case <-q.full:
+		}
+		for {
+			batch, err := q.Flush()
+			if err != nil && q.params.OnError != nil {
+				q.params.OnError(err)
+			}
+			if err != nil || batch.TxID == "" || q.Pending() < q.params.MaxSize {
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,414 @@
+package withdrawal
+
+import (
+	"encoding/hex"
+	"errors"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/glossd/btc/addressinfo"
+	"github.com/glossd/btc/netchain"
+	"github.com/glossd/btc/txutil"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+const privateKey = "cMvRbsVJKjRkZTV7tosWEYEu1x8tQcnLEbC64RiKwPeeEz29j8QZ"
+const destination1 = "mgFv6afUVhrdd3D6mY2iyWzHVk5b64qTok"
+const destination2 = "mwRL1TpsRSFy5KXbxEd2KrHiD16VvbbAdj"
+
+func testParams(t *testing.T, broadcast func(string, netchain.Net) (string, error)) Params {
+	fetch, fetchRawTx := fetchMockWithPrevTxs(t)
+	return Params{
+		CreateParams: txutil.CreateParams{
+			PrivateKey: privateKey,
+			FeeRate:    2,
+			Fetch:      fetch,
+			FetchRawTx: fetchRawTx,
+			Net:        netchain.TestNet,
+		},
+		Broadcast: broadcast,
+	}
+}
+
+// fetchMockWithPrevTxs lists two UTXOs of the address even after they are spent,
+// like the provider which hasn't seen the spending transaction yet.
+func fetchMockWithPrevTxs(t *testing.T) (addressinfo.Fetch, addressinfo.FetchRawTx) {
+	prevTxs := make(map[string]*wire.MsgTx)
+	fetch := func(address string, net netchain.Net) (addressinfo.Address, error) {
+		addr, err := btcutil.DecodeAddress(address, net.GetBtcdNetParams())
+		assert.Nil(t, err)
+		script, err := txscript.PayToAddrScript(addr)
+		assert.Nil(t, err)
+		prevTx := wire.NewMsgTx(wire.TxVersion)
+		prevTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, []byte(address), nil))
+		prevTx.AddTxOut(wire.NewTxOut(addressinfo.MockAddressBalance, script))
+		prevTx.AddTxOut(wire.NewTxOut(addressinfo.MockAddressBalance, script))
+		prevTxs[prevTx.TxHash().String()] = prevTx
+
+		var result addressinfo.Address
+		for i := range prevTx.TxOut {
+			result.UTXOs = append(result.UTXOs, addressinfo.UTXO{
+				TxID:          prevTx.TxHash().String(),
+				Pbscript:      hex.EncodeToString(script),
+				Balance:       addressinfo.MockAddressBalance,
+				TxOutIdx:      i,
+				Confirmations: 6,
+			})
+			result.Balance += addressinfo.MockAddressBalance
+		}
+		return result, nil
+	}
+	fetchRawTx := func(txID string, net netchain.Net) (string, error) {
+		var buf strings.Builder
+		err := prevTxs[txID].Serialize(hex.NewEncoder(&buf))
+		return buf.String(), err
+	}
+	return fetch, fetchRawTx
+}
+
+func broadcastMock(rawTx string, net netchain.Net) (string, error) {
+	decoded, err := txutil.Decode(rawTx, net)
+	return decoded.TxID, err
+}
+
+func TestQueue_Flush(t *testing.T) {
+	store := NewMemoryStore()
+	params := testParams(t, broadcastMock)
+	params.Store = store
+	q, err := New(params)
+	assert.Nil(t, err)
+
+	assert.Nil(t, q.Enqueue(Request{Key: "w1", Address: destination1, Amount: 1000}))
+	assert.Nil(t, q.Enqueue(Request{Key: "w2", Address: destination2, Amount: 2000}))
+	// the same withdrawal requested again
+	assert.Nil(t, q.Enqueue(Request{Key: "w1", Address: destination1, Amount: 1000}))
+	assert.EqualValues(t, 2, q.Pending())
+
+	batch, err := q.Flush()
+	assert.Nil(t, err)
+	assert.NotEmpty(t, batch.TxID)
+	assert.EqualValues(t, 2, len(batch.Requests))
+	assert.True(t, batch.Fee > 0)
+	assert.True(t, batch.Sent)
+	assert.EqualValues(t, 0, q.Pending())
+	assert.EqualValues(t, []Batch{batch}, store.Batches())
+
+	txID, err := store.TxIDOf("w2")
+	assert.Nil(t, err)
+	assert.EqualValues(t, batch.TxID, txID)
+
+	// the sent withdrawal isn't paid twice
+	assert.Nil(t, q.Enqueue(Request{Key: "w1", Address: destination1, Amount: 1000}))
+	assert.EqualValues(t, 0, q.Pending())
+
+	batch, err = q.Flush()
+	assert.Nil(t, err)
+	assert.Empty(t, batch.TxID)
+}
+
+func TestQueue_Rebroadcast(t *testing.T) {
+	fail := true
+	var broadcasted []string
+	store := NewMemoryStore()
+	params := testParams(t, func(rawTx string, net netchain.Net) (string, error) {
+		broadcasted = append(broadcasted, rawTx)
+		if fail {
+			return "", errors.New("node is down")
+		}
+		return broadcastMock(rawTx, net)
+	})
+	params.Store = store
+	q, err := New(params)
+	assert.Nil(t, err)
+	assert.Nil(t, q.Enqueue(Request{Key: "w1", Address: destination1, Amount: 1000}))
+
+	// the transaction is saved before the broadcast, its requests aren't sent in another one
+	unsent, err := q.Flush()
+	assert.NotNil(t, err)
+	assert.NotEmpty(t, unsent.TxID)
+	assert.False(t, unsent.Sent)
+	assert.EqualValues(t, []Batch{unsent}, store.Batches())
+	assert.Nil(t, q.Enqueue(Request{Key: "w1", Address: destination1, Amount: 1000}))
+	assert.EqualValues(t, 0, q.Pending())
+
+	// the same transaction is broadcast again before the newer requests
+	assert.Nil(t, q.Enqueue(Request{Key: "w2", Address: destination2, Amount: 2000}))
+	fail = false
+	batch, err := q.Flush()
+	assert.Nil(t, err)
+	assert.EqualValues(t, unsent.TxID, batch.TxID)
+	assert.True(t, batch.Sent)
+	assert.EqualValues(t, []string{unsent.RawTx, unsent.RawTx}, broadcasted)
+	assert.EqualValues(t, 1, q.Pending())
+	pending, err := store.Unsent()
+	assert.Nil(t, err)
+	assert.Empty(t, pending)
+
+	batch, err = q.Flush()
+	assert.Nil(t, err)
+	assert.EqualValues(t, []Request{{Key: "w2", Address: destination2, Amount: 2000}}, batch.Requests)
+	assert.EqualValues(t, 2, len(store.Batches()))
+}
+
+func TestQueue_RebroadcastAfterRestart(t *testing.T) {
+	store := NewMemoryStore()
+	params := testParams(t, func(rawTx string, net netchain.Net) (string, error) {
+		return "", errors.New("node is down")
+	})
+	params.Store = store
+	q, err := New(params)
+	assert.Nil(t, err)
+	assert.Nil(t, q.Enqueue(Request{Key: "w1", Address: destination1, Amount: 1000}))
+	unsent, err := q.Flush()
+	assert.NotNil(t, err)
+
+	var broadcasted []string
+	params.Broadcast = func(rawTx string, net netchain.Net) (string, error) {
+		broadcasted = append(broadcasted, rawTx)
+		return broadcastMock(rawTx, net)
+	}
+	restarted, err := New(params)
+	assert.Nil(t, err)
+	batch, err := restarted.Flush()
+	assert.Nil(t, err)
+	assert.True(t, batch.Sent)
+	assert.EqualValues(t, []string{unsent.RawTx}, broadcasted)
+}
+
+func TestQueue_Requeue(t *testing.T) {
+	params := testParams(t, broadcastMock)
+	params.Store = failingStore{NewMemoryStore()}
+	q, err := New(params)
+	assert.Nil(t, err)
+	assert.Nil(t, q.Enqueue(Request{Key: "w1", Address: destination1, Amount: 1000}))
+
+	// nothing is broadcast without saving it first
+	_, err = q.Flush()
+	assert.NotNil(t, err)
+	assert.EqualValues(t, 1, q.Pending())
+	assert.Nil(t, q.Enqueue(Request{Key: "w1", Address: destination1, Amount: 1000}))
+	assert.EqualValues(t, 1, q.Pending())
+}
+
+type failingStore struct {
+	*MemoryStore
+}
+
+func (failingStore) Save(batch Batch) error {
+	return errors.New("database is down")
+}
+
+func TestQueue_EnqueueDuringFlush(t *testing.T) {
+	store := &slowStore{MemoryStore: NewMemoryStore()}
+	params := testParams(t, broadcastMock)
+	params.Store = store
+	q, err := New(params)
+	assert.Nil(t, err)
+	assert.Nil(t, q.Enqueue(Request{Key: "w1", Address: destination1, Amount: 1000}))
+
+	// the batch is saved while the Store looks up the same withdrawal requested again
+	flushed := make(chan error, 1)
+	var looked bool
+	store.onLookup = func() {
+		looked = true
+		go func() {
+			_, err := q.Flush()
+			flushed <- err
+		}()
+		select {
+		case err := <-flushed:
+			flushed <- err
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	assert.Nil(t, q.Enqueue(Request{Key: "w1", Address: destination1, Amount: 1000}))
+	store.onLookup = nil
+	if looked {
+		assert.Nil(t, <-flushed)
+	} else {
+		_, err = q.Flush()
+		assert.Nil(t, err)
+	}
+
+	assert.EqualValues(t, 0, q.Pending())
+	assert.EqualValues(t, 1, len(store.Batches()))
+}
+
+// slowStore calls onLookup between reading the key and returning it.
+type slowStore struct {
+	*MemoryStore
+	onLookup func()
+}
+
+func (s *slowStore) TxIDOf(key string) (string, error) {
+	txID, err := s.MemoryStore.TxIDOf(key)
+	if s.onLookup != nil {
+		s.onLookup()
+	}
+	return txID, err
+}
+
+func TestQueue_SpentOutputs(t *testing.T) {
+	params := testParams(t, broadcastMock)
+	q, err := New(params)
+	assert.Nil(t, err)
+
+	spent := make(map[string]bool)
+	for _, key := range []string{"w1", "w2"} {
+		assert.Nil(t, q.Enqueue(Request{Key: key, Address: destination1, Amount: 1000}))
+		batch, err := q.Flush()
+		assert.Nil(t, err)
+		decoded, err := txutil.Decode(batch.RawTx, params.CreateParams.Net)
+		assert.Nil(t, err)
+		for _, in := range decoded.Inputs {
+			// the provider still lists the outputs spent by the previous batch
+			assert.False(t, spent[outPoint(in.TxID, in.TxOutIdx)])
+			spent[outPoint(in.TxID, in.TxOutIdx)] = true
+		}
+	}
+
+	assert.Nil(t, q.Enqueue(Request{Key: "w3", Address: destination1, Amount: 1000}))
+	_, err = q.Flush()
+	assert.True(t, errors.Is(err, txutil.ErrInsufficientFunds))
+	assert.EqualValues(t, 1, q.Pending())
+}
+
+func TestQueue_AlreadyBroadcast(t *testing.T) {
+	q, err := New(testParams(t, func(rawTx string, net netchain.Net) (string, error) {
+		return "", errors.New("Error validating transaction: Transaction with hash ab already exists.")
+	}))
+	assert.Nil(t, err)
+	assert.Nil(t, q.Enqueue(Request{Key: "w1", Address: destination1, Amount: 1000}))
+
+	batch, err := q.Flush()
+	assert.Nil(t, err)
+	assert.True(t, batch.Sent)
+	assert.Empty(t, q.Unsent())
+}
+
+func TestQueue_Drop(t *testing.T) {
+	fail := true
+	store := NewMemoryStore()
+	params := testParams(t, func(rawTx string, net netchain.Net) (string, error) {
+		if fail {
+			return "", errors.New("bad-txns-inputs-missingorspent")
+		}
+		return broadcastMock(rawTx, net)
+	})
+	params.Store = store
+	q, err := New(params)
+	assert.Nil(t, err)
+	assert.Nil(t, q.Enqueue(Request{Key: "w1", Address: destination1, Amount: 1000}))
+	unsent, err := q.Flush()
+	assert.NotNil(t, err)
+	assert.EqualValues(t, []Batch{unsent}, q.Unsent())
+
+	assert.NotNil(t, q.Drop("unknown"))
+	assert.Nil(t, q.Drop(unsent.TxID))
+	assert.Empty(t, q.Unsent())
+	assert.Empty(t, store.Batches())
+	assert.EqualValues(t, 1, q.Pending())
+
+	// the requests are paid by the next batch, which can spend the outputs of the dropped one
+	fail = false
+	batch, err := q.Flush()
+	assert.Nil(t, err)
+	assert.True(t, batch.Sent)
+	assert.EqualValues(t, unsent.Requests, batch.Requests)
+	txID, err := store.TxIDOf("w1")
+	assert.Nil(t, err)
+	assert.EqualValues(t, batch.TxID, txID)
+}
+
+func TestQueue_Replace(t *testing.T) {
+	fail := true
+	store := NewMemoryStore()
+	params := testParams(t, func(rawTx string, net netchain.Net) (string, error) {
+		if fail {
+			return "", errors.New("min relay fee not met")
+		}
+		return broadcastMock(rawTx, net)
+	})
+	params.CreateParams.RBF = true
+	params.Store = store
+	q, err := New(params)
+	assert.Nil(t, err)
+	assert.Nil(t, q.Enqueue(Request{Key: "w1", Address: destination1, Amount: 1000}))
+	unsent, err := q.Flush()
+	assert.NotNil(t, err)
+
+	// the replacement must pay the same requests
+	other, err := txutil.Create(txutil.CreateParams{
+		PrivateKey:  privateKey,
+		Destination: destination2,
+		Amount:      1000,
+		FeeRate:     2,
+		Fetch:       params.CreateParams.Fetch,
+		Net:         params.CreateParams.Net,
+	})
+	assert.Nil(t, err)
+	_, err = q.Replace(unsent, other)
+	assert.NotNil(t, err)
+
+	bumped, err := txutil.BumpFee(unsent.RawTx, 10, txutil.BumpFeeParams{
+		PrivateKeys: []string{privateKey},
+		Net:         params.CreateParams.Net,
+		Fetch:       params.CreateParams.Fetch,
+		FetchRawTx:  params.CreateParams.FetchRawTx,
+	})
+	assert.Nil(t, err)
+	fail = false
+	replaced, err := q.Replace(unsent, bumped)
+	assert.Nil(t, err)
+	assert.True(t, replaced.Sent)
+	assert.NotEqual(t, unsent.TxID, replaced.TxID)
+	assert.Greater(t, replaced.Fee, unsent.Fee)
+	assert.EqualValues(t, unsent.Requests, replaced.Requests)
+	assert.EqualValues(t, []Batch{replaced}, store.Batches())
+	assert.Empty(t, q.Unsent())
+	txID, err := store.TxIDOf("w1")
+	assert.Nil(t, err)
+	assert.EqualValues(t, replaced.TxID, txID)
+}
+
+func TestQueue_Run(t *testing.T) {
+	sent := make(chan string, 2)
+	params := testParams(t, func(rawTx string, net netchain.Net) (string, error) {
+		txID, err := broadcastMock(rawTx, net)
+		sent <- txID
+		return txID, err
+	})
+	params.Interval = time.Hour
+	params.MaxSize = 2
+	q, err := New(params)
+	assert.Nil(t, err)
+	stop := make(chan struct{})
+	defer close(stop)
+	go q.Run(stop)
+
+	assert.Nil(t, q.Enqueue(Request{Key: "w1", Address: destination1, Amount: 1000}))
+	assert.Nil(t, q.Enqueue(Request{Key: "w2", Address: destination2, Amount: 2000}))
+	select {
+	case txID := <-sent:
+		assert.NotEmpty(t, txID)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the full batch wasn't sent before the interval")
+	}
+}
+
+func TestQueue_Enqueue(t *testing.T) {
+	q, err := New(testParams(t, broadcastMock))
+	assert.Nil(t, err)
+	assert.True(t, errors.Is(q.Enqueue(Request{Key: "w1", Address: destination1, Amount: 100}), txutil.ErrDustOutput))
+	assert.True(t, errors.Is(q.Enqueue(Request{Key: "w2", Address: "1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2", Amount: 1000}), txutil.ErrInvalidAddress))
+	assert.NotNil(t, q.Enqueue(Request{Address: destination1, Amount: 1000}))
+	assert.EqualValues(t, 0, q.Pending())
+
+	_, err = New(Params{CreateParams: txutil.CreateParams{SendAll: true}})
+	assert.NotNil(t, err)
+	_, err = New(Params{CreateParams: txutil.CreateParams{Net: "unknown"}})
+	assert.NotNil(t, err)
+}
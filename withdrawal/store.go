@@ -0,0 +1,86 @@
+package withdrawal
+
+import "sync"
+
+// Store persists the batches, e.g. in the database, so that the withdrawals aren't paid twice after a restart.
+type Store interface {
+	// Save records that the transaction pays out the requests. It's called before the broadcast
+	// and again with the Sent batch, the batch of the same TxID must be replaced.
+	Save(batch Batch) error
+	// TxIDOf returns the transaction which pays out the request with the key, empty if it isn't saved.
+	TxIDOf(key string) (string, error)
+	// Unsent returns the saved batches which aren't Sent in the order they were saved.
+	Unsent() ([]Batch, error)
+	// Delete removes the batch of the transaction which won't be mined. The requests saved since
+	// with another transaction must keep it.
+	Delete(txID string) error
+}
+
+// MemoryStore keeps the batches until the process exits.
+type MemoryStore struct {
+	mu      sync.Mutex
+	txIDs   map[string]string
+	batches []Batch
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{txIDs: make(map[string]string)}
+}
+
+func (s *MemoryStore) Save(batch Batch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range batch.Requests {
+		s.txIDs[r.Key] = batch.TxID
+	}
+	for i, b := range s.batches {
+		if b.TxID == batch.TxID {
+			s.batches[i] = batch
+			return nil
+		}
+	}
+	s.batches = append(s.batches, batch)
+	return nil
+}
+
+func (s *MemoryStore) TxIDOf(key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.txIDs[key], nil
+}
+
+func (s *MemoryStore) Delete(txID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, id := range s.txIDs {
+		if id == txID {
+			delete(s.txIDs, key)
+		}
+	}
+	for i, b := range s.batches {
+		if b.TxID == txID {
+			s.batches = append(s.batches[:i], s.batches[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) Unsent() ([]Batch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var unsent []Batch
+	for _, b := range s.batches {
+		if !b.Sent {
+			unsent = append(unsent, b)
+		}
+	}
+	return unsent, nil
+}
+
+// Batches returns the saved batches in the order they were saved.
+func (s *MemoryStore) Batches() []Batch {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Batch(nil), s.batches...)
+}